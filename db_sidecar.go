@@ -4,10 +4,12 @@ import (
 	"bufio"
 	"context"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
 
@@ -34,11 +36,24 @@ const (
 	chunkUpdatePeriod = 1000
 )
 
+// every trendUpdatePeriod chunks (1 minute), store the trends that changed since the last time.
+// trends only end a few minutes after they were last seen, so go back trendOverlap further to catch those too.
+const (
+	trendUpdatePeriod = 20
+	trendOverlap      = 10 * time.Minute
+)
+
 var chunkUpdateChannel = make(chan int)
 var conn *pgx.Conn
 var production = os.Getenv("TOP_TWEETS_MODE") == "PRODUCTION"
 var apiUrl = getApiUrl()
 
+// the sequence number of the last chunk we inserted, to notice when we miss some
+var lastChunkSeq uint64
+
+// when we last stored the trends
+var lastTrendUpdate time.Time
+
 func getApiUrl() string {
 	if production {
 		return "https://toptweets.calderwhite.com:8080"
@@ -89,7 +104,7 @@ func subscribeToAPI() {
 	}
 }
 
-func insertRows(ctx context.Context, diff *lib.WordDiff) {
+func insertRows(ctx context.Context, diff *lib.WordDiff, ts time.Time) {
 	log.Println("Inserting...")
 	// Prepared statement given the name 'ps1'
 	_, err := conn.Prepare(ctx, "ps1", "INSERT INTO word_counts VALUES($1, $2, $3)")
@@ -103,7 +118,6 @@ func insertRows(ctx context.Context, diff *lib.WordDiff) {
 		log.Println(err)
 	}
 
-	ts := time.Now()
 	diff.Walk(func(word string, count int) {
 		_, err = tx.Exec(ctx, "ps1", ts, word, int16(count))
 		if err != nil {
@@ -120,6 +134,14 @@ func insertRows(ctx context.Context, diff *lib.WordDiff) {
 	}
 }
 
+// marks the chunk as part of an outage, so queries over word_counts can leave it out or point it out
+func insertGap(ctx context.Context, chunk *lib.ChunkRecord) {
+	_, err := conn.Exec(ctx, "INSERT INTO chunk_gaps VALUES($1, $2, $3)", chunk.Timestamp(), int64(chunk.Seq), chunk.Tweets)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
 func insertRowsLong(ctx context.Context, diff *lib.WordDiff) {
 	log.Println("Inserting...")
 	// Prepared statement given the name 'ps1'
@@ -153,6 +175,61 @@ func insertRowsLong(ctx context.Context, diff *lib.WordDiff) {
 	}
 }
 
+// mirrors the top_tweets symbol table so the word IDs in its backups and chunks can be resolved from the database.
+func symbolsUpdate(ctx context.Context) {
+	resp, err := http.Get(fmt.Sprintf("%s/api/symbols", apiUrl))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer resp.Body.Close()
+
+	decoder := gob.NewDecoder(resp.Body)
+	symbols := lib.NewSymbolTable()
+	err = decoder.Decode(&symbols)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	log.Println("Inserting symbols...")
+	_, err = conn.Prepare(ctx, "ps3", "INSERT INTO symbols VALUES($1, $2, $3) ON CONFLICT (id) DO UPDATE SET word=$2, refs=$3;")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	var freed []int32
+	for id, word := range symbols.Words {
+		if symbols.Refs[id] <= 0 {
+			freed = append(freed, int32(id))
+			continue
+		}
+		_, err = tx.Exec(ctx, "ps3", int32(id), word, symbols.Refs[id])
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	// drop the words top_tweets freed, and the IDs past the end of its table, e.g. after it started over
+	_, err = tx.Exec(ctx, "DELETE FROM symbols WHERE id = ANY($1) OR id >= $2;", freed, int32(len(symbols.Words)))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+}
+
 func chunkUpdate(ctx context.Context, period string) {
 	var req_url string
 	if period == "focus" {
@@ -170,20 +247,93 @@ func chunkUpdate(ctx context.Context, period string) {
 	// this is fine for small packets (like chunks)
 	// but for the long-term diff it is inefficnet.
 	decoder := gob.NewDecoder(resp.Body)
-	diff := lib.NewWordDiff()
-	err = decoder.Decode(&diff)
+	if period == "focus" {
+		chunk := &lib.ChunkRecord{}
+		err = decoder.Decode(&chunk)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		resp.Body.Close()
+
+		if lastChunkSeq != 0 && chunk.Seq > lastChunkSeq+1 {
+			log.Printf("Missed %d chunks (%d to %d).\n", chunk.Seq-lastChunkSeq-1, lastChunkSeq+1, chunk.Seq-1)
+		}
+		lastChunkSeq = chunk.Seq
+		if chunk.Gap {
+			insertGap(ctx, chunk)
+		}
+		// gob leaves out empty diffs, e.g. for chunks from when the stream was down
+		if chunk.Words == nil {
+			return
+		}
+		// stamp the rows with when the tweets were written, not when we happened to download them
+		insertRows(ctx, chunk.Words, chunk.Timestamp())
+	} else {
+		diff := lib.NewWordDiff()
+		err = decoder.Decode(&diff)
+		if err != nil {
+			//log.Fatal(err)
+			log.Println(err)
+			return
+		}
+		resp.Body.Close()
+
+		insertRowsLong(ctx, diff)
+	}
+}
+
+// stores the start, peak and end of the trends that changed since the last update. Ongoing trends are stored too,
+// and overwritten until they end.
+func trendsUpdate(ctx context.Context) {
+	since := lastTrendUpdate.Add(-trendOverlap)
+	if lastTrendUpdate.IsZero() {
+		// the trends in the last backup of top_tweets
+		since = time.Now().Add(-24 * time.Hour)
+	}
+	now := time.Now()
+	resp, err := http.Get(fmt.Sprintf("%s/api/trends/history?since=%s", apiUrl, url.QueryEscape(since.Format(time.RFC3339))))
 	if err != nil {
-		//log.Fatal(err)
 		log.Println(err)
 		return
 	}
-	resp.Body.Close()
+	defer resp.Body.Close()
 
-	if period == "focus" {
-		insertRows(ctx, diff)
-	} else {
-		insertRowsLong(ctx, diff)
+	var history struct {
+		Trends []lib.Trend `json:"trends"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&history)
+	if err != nil {
+		log.Println(err)
+		return
 	}
+
+	_, err = conn.Prepare(ctx, "ps4", `INSERT INTO trends VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (word, start_ts) DO UPDATE SET end_ts=$3, duration=$4, peak_score=$5, peak_ts=$6, peak_rank=$7, ongoing=$8;`)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, trend := range history.Trends {
+		_, err = tx.Exec(ctx, "ps4", trend.Word, trend.Start, trend.End, trend.Duration, trend.PeakScore, trend.PeakAt, int32(trend.PeakRank), trend.Ongoing)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	lastTrendUpdate = now
 }
 
 func dbWorker() {
@@ -236,6 +386,39 @@ func dbWorker() {
 	// NOTE: Used to have UNIQUE(word). This is bad for performance and was removed.
 	checkError(err)
 
+	// chunks that had far fewer tweets than usual, because the stream was down
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS chunk_gaps(
+		ts TIMESTAMP NOT NULL,
+		seq BIGINT NOT NULL,
+		tweets BIGINT NOT NULL
+	)`)
+	checkError(err)
+
+	// the symbol table of top_tweets. IDs are only stable while a word is referenced, so this is a snapshot.
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS symbols(
+		id INTEGER NOT NULL,
+		word TEXT NOT NULL,
+		refs INTEGER NOT NULL,
+
+		PRIMARY KEY (id)
+	)`)
+	checkError(err)
+
+	// when words entered the top list, peaked and left it
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS trends(
+		word TEXT NOT NULL,
+		start_ts TIMESTAMP NOT NULL,
+		end_ts TIMESTAMP NOT NULL,
+		duration DOUBLE PRECISION NOT NULL,
+		peak_score REAL NOT NULL,
+		peak_ts TIMESTAMP NOT NULL,
+		peak_rank INTEGER NOT NULL,
+		ongoing BOOLEAN NOT NULL,
+
+		PRIMARY KEY (word, start_ts)
+	)`)
+	checkError(err)
+
 	chunkCount := 0
 
 	for {
@@ -244,8 +427,12 @@ func dbWorker() {
 		chunkUpdate(ctx, "focus")
 		chunkCount++
 
+		if chunkCount%trendUpdatePeriod == 0 {
+			trendsUpdate(ctx)
+		}
 		if chunkCount%chunkUpdatePeriod == 0 {
 			chunkUpdate(ctx, "long")
+			symbolsUpdate(ctx)
 		}
 	}
 }
@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"sync"
+)
+
+// LongCounter is what the long term baseline needs: getTop reads it with GetUnlocked while holding the lock.
+// WordDiff is the exact implementation, and ApproxCounter trades accuracy for fixed memory.
+type LongCounter interface {
+	Lock()
+	Unlock()
+	IncWord(word string)
+	GetUnlocked(word string) int
+	Get(word string) int
+	WalkUnlocked(walkFunc func(string, int))
+	Walk(walkFunc func(string, int))
+	Prune(minCount int)
+	Serialize() []byte
+}
+
+// ApproxCounter is a fixed memory LongCounter. Lookups come from a CountMinSketch, so they are never below the
+// true count and are at most epsilon * (total words counted) above it with probability 1 - delta.
+// Enumeration (Walk, Serialize) only sees the K heavy hitters tracked by a SpaceSaving counter, whose counts are
+// at most (total words counted) / K too high.
+type ApproxCounter struct {
+	Sketch *CountMinSketch
+	Top    *SpaceSaving
+	// Maps do not allow concurrent reads and writes in Go, so we must use a mutex
+	mutex sync.Mutex
+}
+
+func NewApproxCounter(epsilon float64, delta float64, k int) *ApproxCounter {
+	c := &ApproxCounter{}
+	c.Sketch = NewCountMinSketch(epsilon, delta)
+	c.Top = NewSpaceSaving(k)
+
+	return c
+}
+
+func (c *ApproxCounter) Lock() {
+	c.mutex.Lock()
+}
+
+func (c *ApproxCounter) Unlock() {
+	c.mutex.Unlock()
+}
+
+func (c *ApproxCounter) AddWord(word string, count int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.Sketch.Add(word, uint32(count))
+	c.Top.Add(word, count)
+}
+
+func (c *ApproxCounter) IncWord(word string) {
+	c.AddWord(word, 1)
+}
+
+func (c *ApproxCounter) GetUnlocked(word string) int {
+	return int(c.Sketch.Estimate(word))
+}
+
+func (c *ApproxCounter) Get(word string) int {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.GetUnlocked(word)
+}
+
+// WalkUnlocked only visits the heavy hitters, using their sketch estimates as the count.
+func (c *ApproxCounter) WalkUnlocked(walkFunc func(string, int)) {
+	c.Top.Walk(func(entry SpaceSavingEntry) {
+		walkFunc(entry.Word, c.GetUnlocked(entry.Word))
+	})
+}
+
+func (c *ApproxCounter) Walk(walkFunc func(string, int)) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.WalkUnlocked(walkFunc)
+}
+
+// Prune stops tracking the heavy hitters whose estimate is at most minCount, so their slots go to words that are
+// actually used. Since the estimates are never too low, those words really are that rare.
+// The sketch can't shrink, the memory used by an ApproxCounter is fixed when it is created.
+func (c *ApproxCounter) Prune(minCount int) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.Top.Filter(func(entry SpaceSavingEntry) bool {
+		return c.GetUnlocked(entry.Word) > minCount
+	})
+}
+
+// ToWordDiff returns the heavy hitters as a WordDiff.
+func (c *ApproxCounter) ToWordDiff() *WordDiff {
+	diff := NewWordDiff()
+	c.Walk(func(word string, count int) {
+		diff.Words[word] = count
+	})
+
+	return diff
+}
+
+// Serialize produces the same format as WordDiff.Serialize with the heavy hitters, so consumers of snapshots
+// don't need to know which counter is in use. Backups gob the ApproxCounter itself.
+func (c *ApproxCounter) Serialize() []byte {
+	return c.ToWordDiff().Serialize()
+}
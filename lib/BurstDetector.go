@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Burst is a stretch of chunks where a word was used at an elevated rate.
+type Burst struct {
+	Word  string    `json:"word"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// the highest state the word reached during the burst. In state s the word is used Base^s times its normal rate.
+	Level int `json:"level"`
+	// the burst has not ended yet, End is the end of the last chunk so far
+	Ongoing bool `json:"ongoing"`
+}
+
+// BurstState is the automaton of one word.
+type BurstState struct {
+	// the cost of the cheapest sequence of states ending in each state, relative to the cheapest one
+	Costs []float64
+	State int
+	// chunks since the word was last out of state 0
+	Idle  int
+	Burst *Burst
+}
+
+// BurstDetector runs Kleinberg's burst automaton online for every word that looks like it might be bursting.
+// The automaton has Levels states, where state s expects the word at Base^s times its baseline rate.
+// Going up a state costs TransitionCost, going down is free, and every chunk costs the negative log likelihood of
+// the word's count under the state's rate. The word is in whichever state has the cheapest history, so it takes
+// sustained evidence to enter or leave a burst, instead of flickering in and out of the top list.
+//
+// Words start being tracked when they are used at least MinCount times in a chunk at more than Base times their
+// normal rate, and are dropped after IdleChunks chunks in state 0. At most MaxWords words are tracked; once that many
+// are, new words have to wait until some are dropped.
+type BurstDetector struct {
+	Levels         int
+	Base           float64
+	Gamma          float64
+	TransitionCost float64
+	MinCount       int
+	IdleChunks     int
+	MaxWords       int
+	// used as the rate of words the baseline has never seen
+	MinRate float64
+	Words   map[string]*BurstState
+	// the most recent bursts that ended, oldest first
+	Bursts    []Burst
+	MaxBursts int
+	mutex     sync.Mutex
+}
+
+// NewBurstDetector creates a detector with the transition cost of Kleinberg's paper, gamma * ln(n),
+// where n is how many chunks make up the window we look at. Words are dropped after n chunks without a burst.
+func NewBurstDetector(levels int, base float64, gamma float64, n int, minCount int, maxWords int, minRate float64, maxBursts int) *BurstDetector {
+	d := &BurstDetector{}
+	d.Levels = levels
+	d.Base = base
+	d.Gamma = gamma
+	d.TransitionCost = gamma * math.Log(float64(n))
+	d.MinCount = minCount
+	d.IdleChunks = n
+	d.MaxWords = maxWords
+	d.MinRate = minRate
+	d.Words = make(map[string]*BurstState)
+	d.MaxBursts = maxBursts
+
+	return d
+}
+
+// SetWindow changes the number of chunks the transition cost and the idle timeout are based on,
+// e.g. after the focus period changed.
+func (d *BurstDetector) SetWindow(n int) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.TransitionCost = d.Gamma * math.Log(float64(n))
+	d.IdleChunks = n
+}
+
+func (d *BurstDetector) Lock() {
+	d.mutex.Lock()
+}
+
+func (d *BurstDetector) Unlock() {
+	d.mutex.Unlock()
+}
+
+// the negative log likelihood of count uses out of tweets at the given rate, leaving out the binomial coefficient
+// since it is the same for every state
+func fitCost(count int, tweets int64, rate float64) float64 {
+	rate = math.Min(rate, 0.9999)
+	return -(float64(count)*math.Log(rate) + float64(tweets-int64(count))*math.Log(1-rate))
+}
+
+// AddChunk runs the automaton of every tracked word over a chunk covering [start, end), starting to track the words
+// that look like they are bursting. rate returns the normal rate of a word per tweet.
+func (d *BurstDetector) AddChunk(start time.Time, end time.Time, tweets int64, words *SealedChunk, rate func(string) float64) {
+	d.Lock()
+	defer d.Unlock()
+
+	if tweets == 0 {
+		return
+	}
+
+	counts := make(map[string]int)
+	if words != nil {
+		words.WalkWords(func(word string, count int) {
+			if _, tracked := d.Words[word]; tracked || count >= d.MinCount {
+				counts[word] = count
+			}
+		})
+	}
+
+	for word, count := range counts {
+		if _, tracked := d.Words[word]; tracked {
+			continue
+		}
+		if len(d.Words) >= d.MaxWords {
+			break
+		}
+		if float64(count) > d.Base*math.Max(rate(word), d.MinRate)*float64(tweets) {
+			state := &BurstState{Costs: make([]float64, d.Levels)}
+			for s := range state.Costs {
+				state.Costs[s] = float64(s) * d.TransitionCost
+			}
+			d.Words[word] = state
+		}
+	}
+
+	for word, state := range d.Words {
+		d.stepUnlocked(word, state, start, end, counts[word], tweets, math.Max(rate(word), d.MinRate))
+		if state.State == 0 && state.Idle >= d.IdleChunks {
+			delete(d.Words, word)
+		}
+	}
+}
+
+func (d *BurstDetector) stepUnlocked(word string, state *BurstState, start time.Time, end time.Time, count int, tweets int64, rate float64) {
+	costs := make([]float64, d.Levels)
+	best := 0
+	for s := range costs {
+		// the cheapest way into state s
+		costs[s] = math.Inf(1)
+		for from, cost := range state.Costs {
+			if s > from {
+				cost += float64(s-from) * d.TransitionCost
+			}
+			costs[s] = math.Min(costs[s], cost)
+		}
+		costs[s] += fitCost(count, tweets, rate*math.Pow(d.Base, float64(s)))
+		if costs[s] < costs[best] {
+			best = s
+		}
+	}
+	// only the differences matter, and this keeps the costs from growing forever
+	for s := range costs {
+		costs[s] -= costs[best]
+	}
+	state.Costs = costs
+	state.State = best
+
+	if best == 0 {
+		state.Idle++
+		if state.Burst != nil {
+			state.Burst.Ongoing = false
+			d.Bursts = append(d.Bursts, *state.Burst)
+			if len(d.Bursts) > d.MaxBursts {
+				d.Bursts = d.Bursts[len(d.Bursts)-d.MaxBursts:]
+			}
+			state.Burst = nil
+		}
+		return
+	}
+
+	state.Idle = 0
+	if state.Burst == nil {
+		state.Burst = &Burst{Word: word, Start: start, Ongoing: true}
+	}
+	state.Burst.End = end
+	if best > state.Burst.Level {
+		state.Burst.Level = best
+	}
+}
+
+// Recent returns the bursts that ended after since and the ongoing ones, optionally only those of one word.
+// The bursts that ended come first, and each part is oldest first.
+func (d *BurstDetector) Recent(since time.Time, word string) []Burst {
+	d.Lock()
+	defer d.Unlock()
+
+	bursts := make([]Burst, 0)
+	for _, burst := range d.Bursts {
+		if burst.End.After(since) && (word == "" || burst.Word == word) {
+			bursts = append(bursts, burst)
+		}
+	}
+	ended := len(bursts)
+	for _, state := range d.Words {
+		if state.Burst != nil && (word == "" || state.Burst.Word == word) {
+			bursts = append(bursts, *state.Burst)
+		}
+	}
+	ongoing := bursts[ended:]
+	sort.Slice(ongoing, func(i, j int) bool { return ongoing[i].Start.Before(ongoing[j].Start) })
+
+	return bursts
+}
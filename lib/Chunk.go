@@ -0,0 +1,131 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log"
+	"sync"
+	"time"
+)
+
+// Chunk is one entry of the wordDiffQueue: the words of every tweet that arrived in one chunk period,
+// along with when those tweets were created and when the chunk was produced.
+type Chunk struct {
+	// increases by one for every chunk, so consumers can tell if they missed any
+	Seq uint64
+	// the start of the chunk period the chunk covers
+	Start time.Time
+	// the earliest and latest created_at of the tweets in the chunk. Zero if it has no tweets.
+	FirstCreatedAt time.Time
+	LastCreatedAt  time.Time
+	// when the chunk was sealed and pushed to the queue
+	IngestedAt time.Time
+	Tweets     int64
+	// tweets that were received during the chunk but could not be counted
+	Dropped int64
+	// the chunk got far fewer tweets than usual, most likely because the stream was down
+	Gap   bool
+	Words *SealedChunk
+	// how many tweets used both words, for pairs of trending words, and how many used each of those words.
+	// See CoOccurrence.
+	Pairs     map[WordPair]int
+	PairWords map[string]int
+	// how the words were written, when that differs from how they are counted. See DisplayForms.
+	Forms map[DisplayForm]int
+	// readers that still use Words, which is only released once the chunk is evicted and they are done. See Retain.
+	readers int
+	evicted bool
+	mutex   sync.Mutex
+}
+
+// Retain keeps the chunk's words around until the matching Release, even if the chunk is evicted in the meantime.
+// Only call it while the chunk is still in the queue.
+func (c *Chunk) Retain() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.readers++
+}
+
+// Release ends a Retain.
+func (c *Chunk) Release() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.readers--
+	if c.readers == 0 && c.evicted {
+		c.Words.Release()
+	}
+}
+
+// Evict is called once the chunk has left the queue. Its words are released as soon as no reader holds it.
+func (c *Chunk) Evict() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.evicted = true
+	if c.readers == 0 {
+		c.Words.Release()
+	}
+}
+
+// ChunkRecord is a Chunk with its words resolved, which is what is sent to the sidecar.
+type ChunkRecord struct {
+	Seq            uint64
+	Start          time.Time
+	FirstCreatedAt time.Time
+	LastCreatedAt  time.Time
+	IngestedAt     time.Time
+	Tweets         int64
+	Dropped        int64
+	Gap            bool
+	Words          *WordDiff
+}
+
+// AddCreatedAt widens the chunk's created_at range to include createdAt.
+func (c *Chunk) AddCreatedAt(createdAt time.Time) {
+	if createdAt.IsZero() {
+		return
+	}
+	if c.FirstCreatedAt.IsZero() || createdAt.Before(c.FirstCreatedAt) {
+		c.FirstCreatedAt = createdAt
+	}
+	if createdAt.After(c.LastCreatedAt) {
+		c.LastCreatedAt = createdAt
+	}
+}
+
+// Timestamp is the best guess of when the chunk's tweets were written: the latest created_at,
+// or the time it was ingested if we don't know that.
+func (c *ChunkRecord) Timestamp() time.Time {
+	if !c.LastCreatedAt.IsZero() {
+		return c.LastCreatedAt
+	}
+
+	return c.IngestedAt
+}
+
+func (c *Chunk) Record() *ChunkRecord {
+	return &ChunkRecord{
+		Seq:            c.Seq,
+		Start:          c.Start,
+		FirstCreatedAt: c.FirstCreatedAt,
+		LastCreatedAt:  c.LastCreatedAt,
+		IngestedAt:     c.IngestedAt,
+		Tweets:         c.Tweets,
+		Dropped:        c.Dropped,
+		Gap:            c.Gap,
+		Words:          c.Words.ToWordDiff(),
+	}
+}
+
+func (c *ChunkRecord) Serialize() []byte {
+	buffer := bytes.NewBuffer([]byte{})
+	encoder := gob.NewEncoder(buffer)
+	err := encoder.Encode(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
@@ -0,0 +1,226 @@
+package lib
+
+import (
+	"sort"
+	"sync"
+)
+
+// WordPair is an unordered pair of words, with A < B.
+type WordPair struct {
+	A string
+	B string
+}
+
+func NewWordPair(a string, b string) WordPair {
+	if b < a {
+		a, b = b, a
+	}
+
+	return WordPair{A: a, B: b}
+}
+
+// CoOccurrence counts how many tweets of the focus window use both words of a pair, for the candidate words only.
+// Counting every pair would be quadratic in the words of every tweet, and only the words that are trending can end up
+// in a story anyway. A word's pairs start being counted once it becomes a candidate, so a word that just started
+// trending has less history than the others.
+// The number of tweets that used each candidate word is counted the same way, so the pairs can be compared to it.
+// The pairs of every tweet are also added to its chunk, which are subtracted again when the chunk leaves the window.
+type CoOccurrence struct {
+	Pairs map[WordPair]int
+	// how many tweets used the word while it was a candidate
+	Tweets     map[string]int
+	candidates map[string]bool
+	mutex      sync.Mutex
+}
+
+func NewCoOccurrence() *CoOccurrence {
+	c := &CoOccurrence{}
+	c.Pairs = make(map[WordPair]int)
+	c.Tweets = make(map[string]int)
+	c.candidates = make(map[string]bool)
+
+	return c
+}
+
+func (c *CoOccurrence) Lock() {
+	c.mutex.Lock()
+}
+
+func (c *CoOccurrence) Unlock() {
+	c.mutex.Unlock()
+}
+
+// SetCandidates replaces the words whose pairs are counted from now on.
+func (c *CoOccurrence) SetCandidates(words []string) {
+	candidates := make(map[string]bool, len(words))
+	for _, word := range words {
+		candidates[word] = true
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.candidates = candidates
+}
+
+// CountTweet counts the candidate words of one tweet and their pairs, in the totals and in the tweet's chunk.
+func (c *CoOccurrence) CountTweet(words []string, chunk *Chunk) {
+	c.Lock()
+	defer c.Unlock()
+
+	var found []string
+	for _, word := range words {
+		if !c.candidates[word] {
+			continue
+		}
+		duplicate := false
+		for _, other := range found {
+			if other == word {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			found = append(found, word)
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+
+	if chunk.PairWords == nil {
+		chunk.PairWords = make(map[string]int)
+	}
+	for _, word := range found {
+		c.Tweets[word]++
+		chunk.PairWords[word]++
+	}
+	if len(found) < 2 {
+		return
+	}
+
+	if chunk.Pairs == nil {
+		chunk.Pairs = make(map[WordPair]int)
+	}
+	for i := range found {
+		for j := i + 1; j < len(found); j++ {
+			pair := NewWordPair(found[i], found[j])
+			c.Pairs[pair]++
+			chunk.Pairs[pair]++
+		}
+	}
+}
+
+// SubChunk removes the pairs of a chunk that left the window.
+func (c *CoOccurrence) SubChunk(chunk *Chunk) {
+	c.Lock()
+	defer c.Unlock()
+
+	for pair, count := range chunk.Pairs {
+		c.Pairs[pair] -= count
+		if c.Pairs[pair] <= 0 {
+			delete(c.Pairs, pair)
+		}
+	}
+	for word, count := range chunk.PairWords {
+		c.Tweets[word] -= count
+		if c.Tweets[word] <= 0 {
+			delete(c.Tweets, word)
+		}
+	}
+}
+
+// AddChunk adds the pairs of a chunk back, e.g. when the window is restored from a backup.
+func (c *CoOccurrence) AddChunk(chunk *Chunk) {
+	c.Lock()
+	defer c.Unlock()
+
+	for pair, count := range chunk.Pairs {
+		c.Pairs[pair] += count
+	}
+	for word, count := range chunk.PairWords {
+		c.Tweets[word] += count
+	}
+}
+
+// StoryWord is a word that goes into Stories, with its count in the window and its score.
+type StoryWord struct {
+	Word  string  `json:"word"`
+	Count int     `json:"count"`
+	Score float32 `json:"score"`
+}
+
+// Story is a group of trending words that are used together, most likely about the same event.
+type Story struct {
+	// best scoring first
+	Words []StoryWord `json:"words"`
+	Score float32     `json:"score"`
+	Size  int         `json:"size"`
+}
+
+// Stories clusters words into the connected components of the co-occurrence graph. Two words are linked when at
+// least minPairs tweets use both, and those are at least minOverlap of the tweets of the less used word.
+// Requiring the overlap keeps a common word from chaining unrelated stories together. The overlap compares tweets to
+// tweets, both counted while the words were candidates, so a word repeated within a tweet doesn't water it down.
+// The stories are ordered by their combined score, best first.
+func (c *CoOccurrence) Stories(words []StoryWord, minPairs int, minOverlap float64) []Story {
+	index := make(map[string]int, len(words))
+	for i, word := range words {
+		index[word.Word] = i
+	}
+
+	// union find over the words
+	parent := make([]int, len(words))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	c.Lock()
+	for pair, count := range c.Pairs {
+		if count < minPairs {
+			continue
+		}
+		a, foundA := index[pair.A]
+		b, foundB := index[pair.B]
+		if !foundA || !foundB {
+			continue
+		}
+		smaller := c.Tweets[pair.A]
+		if c.Tweets[pair.B] < smaller {
+			smaller = c.Tweets[pair.B]
+		}
+		if smaller == 0 || float64(count)/float64(smaller) < minOverlap {
+			continue
+		}
+		parent[find(a)] = find(b)
+	}
+	c.Unlock()
+
+	components := make(map[int]*Story)
+	for i, word := range words {
+		root := find(i)
+		story, ok := components[root]
+		if !ok {
+			story = &Story{}
+			components[root] = story
+		}
+		story.Words = append(story.Words, word)
+		story.Score += word.Score
+		story.Size++
+	}
+
+	stories := make([]Story, 0, len(components))
+	for _, story := range components {
+		sort.Slice(story.Words, func(i, j int) bool { return story.Words[i].Score > story.Words[j].Score })
+		stories = append(stories, *story)
+	}
+	sort.Slice(stories, func(i, j int) bool { return stories[i].Score > stories[j].Score })
+
+	return stories
+}
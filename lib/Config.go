@@ -0,0 +1,352 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config is everything about the windows and the scoring that can be tuned without a code change.
+// It is loaded from, in increasing order of precedence: the defaults, a JSON file, TOP_TWEETS_* env vars and flags.
+type Config struct {
+	// how often the current chunk is pushed to the focus window. Chunks are cut on wall clock boundaries
+	// (not every N tweets), so the focus window always spans the same amount of time no matter the stream's volume.
+	ChunkPeriod time.Duration
+	// the number of chunks in the focus window. Once exceeded, the oldest chunk is dropped.
+	// (300) * (3s) -- last 15 minutes
+	FocusPeriod int
+	// after this many tweets, we will prune all (1) counts in the long term counts, and (0) counts in the focus window.
+	// 0 counts have literally no impact, and 1 counts have an infinitesimal impact on the long term rates.
+	// we use 360k because we get a min of 40 tweets/second, which means this will prune every 2.5 hours
+	LongPrunePeriod int
+	// we can have a shorter prune period for the short term pruning since it just prunes 0s
+	FocusPrunePeriod int
+	// the scorer used for the cached top list, see the scorers in twitter_worker
+	Scorer string
+	// the multiple scorer. MinCount and MaxAdjustedCount were tuned on a window of ThresholdTweets tweets,
+	// so they are scaled for windows with more or fewer tweets.
+	MinMultiple      float32
+	MaxMultiple      float32
+	MinCount         float32
+	MaxAdjustedCount float32
+	ThresholdTweets  float32
+	// the public top list, see TopBoard. Setting boardAlpha, boardEnterRatio and boardExitRatio to 1 and
+	// boardMinDwell to 0 turns the smoothing off.
+	BoardMinDwell   time.Duration
+	BoardAlpha      float32
+	BoardEnterRatio float32
+	BoardExitRatio  float32
+	// what the focus window is compared against: "cumulative" (every count since the process first started),
+	// "decayed" (counts that halve every HalfLife) or "window" (the last LongWindow).
+	Baseline   string
+	HalfLife   time.Duration
+	LongWindow time.Duration
+	// "exact" or "approx", which counts the long term counts in fixed memory
+	LongCounter string
+	// "arrival" puts tweets into chunks by when they arrive, "event" by their created_at. Tweets created more than
+	// AllowedLateness before the latest one are dropped then.
+	Time            string
+	AllowedLateness time.Duration
+	// whether the example tweets keep their text, or just their IDs
+	SampleText bool
+	// how many of the latest tweets /api/tweets/search searches, which takes around 350 bytes each with the index.
+	// 0 turns the search off.
+	SearchTweets int
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		ChunkPeriod:      3 * time.Second,
+		FocusPeriod:      300,
+		LongPrunePeriod:  360000,
+		FocusPrunePeriod: 10000,
+		Scorer:           "multiple",
+		MinMultiple:      2,
+		MaxMultiple:      15,
+		MinCount:         100,
+		MaxAdjustedCount: 3000,
+		// 300 chunks of 300 tweets
+		ThresholdTweets: 90000,
+		BoardMinDwell:   30 * time.Second,
+		BoardAlpha:      0.3,
+		BoardEnterRatio: 1.1,
+		BoardExitRatio:  0.8,
+		Baseline:        "cumulative",
+		HalfLife:        72 * time.Hour,
+		LongWindow:      7 * 24 * time.Hour,
+		LongCounter:     "exact",
+		Time:            "arrival",
+		AllowedLateness: 10 * time.Second,
+		SampleText:      true,
+		// ~10MB
+		SearchTweets: 30000,
+	}
+}
+
+// configField describes one setting: its key in the file, its env var and flag, and whether a reload can change it.
+type configField struct {
+	key   string
+	env   string
+	flag  string
+	usage string
+	// the setting can change while running. The others need a restart.
+	hot bool
+	ptr func(c *Config) interface{}
+}
+
+var configFields = []configField{
+	{"chunkPeriod", "TOP_TWEETS_CHUNK_PERIOD", "chunk-period", "how often a chunk is cut, e.g. 3s", false,
+		func(c *Config) interface{} { return &c.ChunkPeriod }},
+	{"focusPeriod", "TOP_TWEETS_FOCUS_PERIOD", "focus-period", "chunks in the focus window", true,
+		func(c *Config) interface{} { return &c.FocusPeriod }},
+	{"longPrunePeriod", "TOP_TWEETS_LONG_PRUNE_PERIOD", "long-prune-period", "tweets between prunes of the long term counts", true,
+		func(c *Config) interface{} { return &c.LongPrunePeriod }},
+	{"focusPrunePeriod", "TOP_TWEETS_FOCUS_PRUNE_PERIOD", "focus-prune-period", "tweets between prunes of the focus window", true,
+		func(c *Config) interface{} { return &c.FocusPrunePeriod }},
+	{"scorer", "TOP_TWEETS_SCORER", "scorer", "scorer used for the cached top list", true,
+		func(c *Config) interface{} { return &c.Scorer }},
+	{"minMultiple", "TOP_TWEETS_MIN_MULTIPLE", "min-multiple", "times the usual rate a word needs to rank", true,
+		func(c *Config) interface{} { return &c.MinMultiple }},
+	{"maxMultiple", "TOP_TWEETS_MAX_MULTIPLE", "max-multiple", "multiple at which the multiple half of the score maxes out", true,
+		func(c *Config) interface{} { return &c.MaxMultiple }},
+	{"minCount", "TOP_TWEETS_MIN_COUNT", "min-count", "uses a word needs to rank, per ThresholdTweets tweets", true,
+		func(c *Config) interface{} { return &c.MinCount }},
+	{"maxAdjustedCount", "TOP_TWEETS_MAX_ADJUSTED_COUNT", "max-adjusted-count", "count at which the count half of the score maxes out, per ThresholdTweets tweets", true,
+		func(c *Config) interface{} { return &c.MaxAdjustedCount }},
+	{"thresholdTweets", "TOP_TWEETS_THRESHOLD_TWEETS", "threshold-tweets", "window size the count thresholds were tuned on", true,
+		func(c *Config) interface{} { return &c.ThresholdTweets }},
+	{"boardMinDwell", "TOP_TWEETS_BOARD_MIN_DWELL", "board-min-dwell", "time a word stays on the public top list at least, e.g. 30s", true,
+		func(c *Config) interface{} { return &c.BoardMinDwell }},
+	{"boardAlpha", "TOP_TWEETS_BOARD_ALPHA", "board-alpha", "weight of the latest score in the smoothed scores of the public top list", true,
+		func(c *Config) interface{} { return &c.BoardAlpha }},
+	{"boardEnterRatio", "TOP_TWEETS_BOARD_ENTER_RATIO", "board-enter-ratio", "times the score of the weakest word on the public top list a word needs to replace it", true,
+		func(c *Config) interface{} { return &c.BoardEnterRatio }},
+	{"boardExitRatio", "TOP_TWEETS_BOARD_EXIT_RATIO", "board-exit-ratio", "times the cut-off score below which a word leaves the public top list", true,
+		func(c *Config) interface{} { return &c.BoardExitRatio }},
+	{"baseline", "TOP_TWEETS_BASELINE", "baseline", "what the focus window is compared against: cumulative, decayed or window", false,
+		func(c *Config) interface{} { return &c.Baseline }},
+	{"halfLife", "TOP_TWEETS_HALF_LIFE", "half-life", "half life of the decayed baseline, e.g. 72h", false,
+		func(c *Config) interface{} { return &c.HalfLife }},
+	{"longWindow", "TOP_TWEETS_LONG_WINDOW", "long-window", "length of the window baseline, e.g. 168h", false,
+		func(c *Config) interface{} { return &c.LongWindow }},
+	{"longCounter", "TOP_TWEETS_LONG_COUNTER", "long-counter", "how the long term counts are kept: exact or approx", false,
+		func(c *Config) interface{} { return &c.LongCounter }},
+	{"time", "TOP_TWEETS_TIME", "time", "what puts tweets into chunks: arrival or event (created_at)", false,
+		func(c *Config) interface{} { return &c.Time }},
+	{"allowedLateness", "TOP_TWEETS_ALLOWED_LATENESS", "allowed-lateness", "how late a tweet can be with time=event, e.g. 10s", false,
+		func(c *Config) interface{} { return &c.AllowedLateness }},
+	{"sampleText", "TOP_TWEETS_SAMPLE_TEXT", "sample-text", "keep the text of example tweets, not just their IDs", false,
+		func(c *Config) interface{} { return &c.SampleText }},
+	{"searchTweets", "TOP_TWEETS_SEARCH_TWEETS", "search-tweets", "latest tweets kept for search, 0 turns the search off", false,
+		func(c *Config) interface{} { return &c.SearchTweets }},
+}
+
+func setConfigValue(ptr interface{}, value string) error {
+	switch p := ptr.(type) {
+	case *time.Duration:
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *float32:
+		v, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return err
+		}
+		*p = float32(v)
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *string:
+		*p = value
+	}
+
+	return nil
+}
+
+// ConfigLoader remembers where the config came from, so it can be loaded again the same way on a reload.
+type ConfigLoader struct {
+	Path  string
+	flags map[string]string
+}
+
+// RegisterFlags adds a flag for every setting, and -config for the file, to fs.
+// The config file path can also come from TOP_TWEETS_CONFIG.
+func (l *ConfigLoader) RegisterFlags(fs *flag.FlagSet) {
+	l.flags = make(map[string]string)
+	l.Path = os.Getenv("TOP_TWEETS_CONFIG")
+	fs.StringVar(&l.Path, "config", l.Path, "JSON config file, reloaded on SIGHUP")
+	for _, field := range configFields {
+		field := field
+		name := field.flag
+		fs.Func(name, field.usage, func(value string) error {
+			l.flags[name] = value
+			return setConfigValue(field.ptr(DefaultConfig()), value)
+		})
+	}
+}
+
+// Load builds the config from the defaults, the file, the env and the flags, and validates it.
+func (l *ConfigLoader) Load() (*Config, error) {
+	c := DefaultConfig()
+
+	if l.Path != "" {
+		data, err := ioutil.ReadFile(l.Path)
+		if err != nil {
+			return nil, err
+		}
+		values := make(map[string]interface{})
+		err = json.Unmarshal(data, &values)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", l.Path, err)
+		}
+		for _, field := range configFields {
+			value, found := values[field.key]
+			if !found {
+				continue
+			}
+			delete(values, field.key)
+			// numbers would come out as e.g. 1e+06 otherwise
+			if number, ok := value.(float64); ok {
+				value = strconv.FormatFloat(number, 'f', -1, 64)
+			}
+			err = setConfigValue(field.ptr(c), fmt.Sprint(value))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", l.Path, field.key, err)
+			}
+		}
+		for key := range values {
+			return nil, fmt.Errorf("%s: unknown setting %q", l.Path, key)
+		}
+	}
+
+	for _, field := range configFields {
+		value := os.Getenv(field.env)
+		if value == "" {
+			continue
+		}
+		err := setConfigValue(field.ptr(c), value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field.env, err)
+		}
+	}
+
+	for _, field := range configFields {
+		value, found := l.flags[field.flag]
+		if !found {
+			continue
+		}
+		err := setConfigValue(field.ptr(c), value)
+		if err != nil {
+			return nil, fmt.Errorf("-%s: %w", field.flag, err)
+		}
+	}
+
+	return c, c.Validate()
+}
+
+func (c *Config) Validate() error {
+	switch {
+	case c.ChunkPeriod < 100*time.Millisecond:
+		return errors.New("chunkPeriod must be at least 100ms")
+	case c.FocusPeriod < 2:
+		return errors.New("focusPeriod must be at least 2 chunks")
+	case c.LongPrunePeriod <= 0 || c.FocusPrunePeriod <= 0:
+		return errors.New("prune periods must be positive")
+	case c.MinMultiple < 0 || c.MaxMultiple <= c.MinMultiple:
+		return errors.New("minMultiple must be at least 0 and below maxMultiple")
+	case c.MinCount < 0:
+		return errors.New("minCount can't be negative")
+	case c.MaxAdjustedCount <= 0 || c.ThresholdTweets <= 0:
+		return errors.New("maxAdjustedCount and thresholdTweets must be positive")
+	case c.BoardMinDwell < 0:
+		return errors.New("boardMinDwell can't be negative")
+	case c.BoardAlpha <= 0 || c.BoardAlpha > 1:
+		return errors.New("boardAlpha must be above 0 and at most 1")
+	case c.BoardExitRatio < 0 || c.BoardEnterRatio < c.BoardExitRatio:
+		return errors.New("boardExitRatio must be at least 0 and at most boardEnterRatio")
+	case c.Baseline != "cumulative" && c.Baseline != "decayed" && c.Baseline != "window":
+		return errors.New("baseline must be cumulative, decayed or window")
+	case c.HalfLife <= 0 || c.LongWindow <= 0:
+		return errors.New("halfLife and longWindow must be positive")
+	case c.LongCounter != "exact" && c.LongCounter != "approx":
+		return errors.New("longCounter must be exact or approx")
+	case c.Time != "arrival" && c.Time != "event":
+		return errors.New("time must be arrival or event")
+	case c.AllowedLateness < 0:
+		return errors.New("allowedLateness can't be negative")
+	case c.SearchTweets < 0:
+		return errors.New("searchTweets can't be negative")
+	}
+
+	return nil
+}
+
+// ColdChanges lists the settings that differ between c and other but can't change without a restart.
+func (c *Config) ColdChanges(other *Config) []string {
+	var changed []string
+	for _, field := range configFields {
+		if field.hot {
+			continue
+		}
+		if configString(field.ptr(c)) != configString(field.ptr(other)) {
+			changed = append(changed, field.key)
+		}
+	}
+
+	return changed
+}
+
+// KeepCold sets the settings that can't change without a restart back to their values in other.
+func (c *Config) KeepCold(other *Config) {
+	for _, field := range configFields {
+		if !field.hot {
+			setConfigValue(field.ptr(c), configString(field.ptr(other)))
+		}
+	}
+}
+
+// Changes describes every setting that differs between c and other, e.g. "focusPeriod: 300 -> 200".
+func (c *Config) Changes(other *Config) []string {
+	var changed []string
+	for _, field := range configFields {
+		before, after := configString(field.ptr(c)), configString(field.ptr(other))
+		if before != after {
+			changed = append(changed, fmt.Sprintf("%s: %s -> %s", field.key, before, after))
+		}
+	}
+
+	return changed
+}
+
+func configString(ptr interface{}) string {
+	switch p := ptr.(type) {
+	case *time.Duration:
+		return p.String()
+	case *int:
+		return strconv.Itoa(*p)
+	case *float32:
+		return strconv.FormatFloat(float64(*p), 'g', -1, 32)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *string:
+		return *p
+	}
+
+	return ""
+}
@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// CountMinSketch counts words approximately in a fixed amount of memory.
+//
+// With Width = ceil(e / epsilon) and Depth = ceil(ln(1 / delta)), an estimate is never below the true count,
+// and with probability 1 - delta it is at most epsilon * Total above it. Total is the sum of all increments.
+// We use conservative updates (only raising the counters that are at the minimum), which keeps the same bound
+// while making the overestimates noticeably smaller in practice.
+type CountMinSketch struct {
+	Width  int
+	Depth  int
+	Total  uint64
+	Counts []uint32
+}
+
+func NewCountMinSketch(epsilon float64, delta float64) *CountMinSketch {
+	s := &CountMinSketch{}
+	s.Width = int(math.Ceil(math.E / epsilon))
+	s.Depth = int(math.Ceil(math.Log(1 / delta)))
+	s.Counts = make([]uint32, s.Width*s.Depth)
+
+	return s
+}
+
+// the index of word in every row, using double hashing so we only hash the word once.
+func (s *CountMinSketch) indices(word string, indices []int) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(word))
+	sum := hasher.Sum64()
+	h1 := sum & 0xffffffff
+	h2 := sum>>32 | 1
+
+	for row := 0; row < s.Depth; row++ {
+		indices[row] = row*s.Width + int((h1+uint64(row)*h2)%uint64(s.Width))
+	}
+}
+
+func (s *CountMinSketch) estimate(indices []int) uint32 {
+	estimate := uint32(math.MaxUint32)
+	for _, i := range indices {
+		if s.Counts[i] < estimate {
+			estimate = s.Counts[i]
+		}
+	}
+
+	return estimate
+}
+
+// Add increments word by count and returns the new estimate of its count.
+func (s *CountMinSketch) Add(word string, count uint32) uint32 {
+	indices := make([]int, s.Depth)
+	s.indices(word, indices)
+	s.Total += uint64(count)
+
+	estimate := s.estimate(indices) + count
+	for _, i := range indices {
+		if s.Counts[i] < estimate {
+			s.Counts[i] = estimate
+		}
+	}
+
+	return estimate
+}
+
+// Estimate returns an upper bound of the count of word. See CountMinSketch for how far off it can be.
+func (s *CountMinSketch) Estimate(word string) uint32 {
+	indices := make([]int, s.Depth)
+	s.indices(word, indices)
+
+	return s.estimate(indices)
+}
+
+// ErrorBound returns epsilon * Total, the most an estimate is off by with probability 1 - delta.
+func (s *CountMinSketch) ErrorBound() float64 {
+	return math.E / float64(s.Width) * float64(s.Total)
+}
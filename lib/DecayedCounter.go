@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// DecayedCount is a count that loses half its value every HalfLife, as of Stamp (unix nanoseconds).
+type DecayedCount struct {
+	Value float64
+	Stamp int64
+}
+
+// DecayedCounter counts words and tweets with exponential decay, so it describes how words are used *lately*
+// instead of since the process first started. Words are only decayed when they are touched (or read), so an update
+// costs the same as incrementing a WordDiff.
+// The clock only moves forward through AddTweet, which lets the caller decide what time means (arrival or creation).
+type DecayedCounter struct {
+	HalfLife time.Duration
+	Now      int64
+	Tweets   DecayedCount
+	Words    map[string]DecayedCount
+	// Maps do not allow concurrent reads and writes in Go, so we must use a mutex
+	mutex sync.Mutex
+}
+
+func NewDecayedCounter(halfLife time.Duration) *DecayedCounter {
+	c := &DecayedCounter{}
+	c.HalfLife = halfLife
+	c.Words = make(map[string]DecayedCount)
+
+	return c
+}
+
+func (c *DecayedCounter) Lock() {
+	c.mutex.Lock()
+}
+
+func (c *DecayedCounter) Unlock() {
+	c.mutex.Unlock()
+}
+
+func (c *DecayedCounter) decay(count DecayedCount) float64 {
+	if c.Now <= count.Stamp {
+		return count.Value
+	}
+
+	return count.Value * math.Exp2(-float64(c.Now-count.Stamp)/float64(c.HalfLife))
+}
+
+// AddTweet moves the clock to at (if it is later than the current time) and counts one tweet.
+func (c *DecayedCounter) AddTweet(at time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	if at.UnixNano() > c.Now {
+		c.Now = at.UnixNano()
+	}
+	c.Tweets = DecayedCount{Value: c.decay(c.Tweets) + 1, Stamp: c.Now}
+}
+
+// AddWord increments word by count as of the current time.
+func (c *DecayedCounter) AddWord(word string, count float64) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.Words[word] = DecayedCount{Value: c.decay(c.Words[word]) + count, Stamp: c.Now}
+}
+
+func (c *DecayedCounter) IncWord(word string) {
+	c.AddWord(word, 1)
+}
+
+// GetUnlocked returns the decayed count of word as of the current time.
+func (c *DecayedCounter) GetUnlocked(word string) float64 {
+	return c.decay(c.Words[word])
+}
+
+func (c *DecayedCounter) Get(word string) float64 {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.GetUnlocked(word)
+}
+
+// RateUnlocked returns how many times word is used per tweet, weighted towards recent tweets.
+func (c *DecayedCounter) RateUnlocked(word string) float64 {
+	tweets := c.decay(c.Tweets)
+	if tweets == 0 {
+		return 0
+	}
+
+	return c.GetUnlocked(word) / tweets
+}
+
+func (c *DecayedCounter) Rate(word string) float64 {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.RateUnlocked(word)
+}
+
+func (c *DecayedCounter) WalkUnlocked(walkFunc func(string, float64)) {
+	for word, count := range c.Words {
+		walkFunc(word, c.decay(count))
+	}
+}
+
+func (c *DecayedCounter) Walk(walkFunc func(string, float64)) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.WalkUnlocked(walkFunc)
+}
+
+// Prune removes the words that have decayed below minValue. Unlike WordDiff.Prune this is EXCLUSIVE.
+func (c *DecayedCounter) Prune(minValue float64) {
+	c.Lock()
+	defer c.Unlock()
+
+	for word, count := range c.Words {
+		if c.decay(count) < minValue {
+			delete(c.Words, word)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"sync"
+)
+
+// DisplayForm is one way a word is written, e.g. "NASA" for "nasa".
+type DisplayForm struct {
+	Key  string
+	Form string
+}
+
+// DisplayForms counts how the words of the focus window were written before they were lowercased, so they can be
+// shown the way people write them ("NASA", "iPhone") instead of how they are counted ("nasa", "iphone").
+// Only the forms that differ from the key are counted; the rest of a word's count is the lowercase form.
+// At most MaxForms forms are counted at a time, new forms are left out until old ones leave the window.
+// Like CoOccurrence, the forms of every tweet are also added to its chunk, and subtracted when the chunk leaves.
+type DisplayForms struct {
+	MaxForms int
+	forms    map[string]map[string]int
+	size     int
+	mutex    sync.Mutex
+}
+
+func NewDisplayForms(maxForms int) *DisplayForms {
+	d := &DisplayForms{}
+	d.MaxForms = maxForms
+	d.forms = make(map[string]map[string]int)
+
+	return d
+}
+
+func (d *DisplayForms) Lock() {
+	d.mutex.Lock()
+}
+
+func (d *DisplayForms) Unlock() {
+	d.mutex.Unlock()
+}
+
+func (d *DisplayForms) addUnlocked(form DisplayForm, count int) bool {
+	forms, found := d.forms[form.Key]
+	if !found {
+		if d.size >= d.MaxForms {
+			return false
+		}
+		forms = make(map[string]int)
+		d.forms[form.Key] = forms
+	}
+	if _, found := forms[form.Form]; !found {
+		if d.size >= d.MaxForms {
+			return false
+		}
+		d.size++
+	}
+	forms[form.Form] += count
+
+	return true
+}
+
+// CountTweet counts how the words of one tweet were written. keys are the words as counted and forms as written,
+// in the same order.
+func (d *DisplayForms) CountTweet(keys []string, forms []string, chunk *Chunk) {
+	d.Lock()
+	defer d.Unlock()
+
+	for i, key := range keys {
+		if forms[i] == key {
+			continue
+		}
+		form := DisplayForm{Key: key, Form: forms[i]}
+		if !d.addUnlocked(form, 1) {
+			continue
+		}
+		if chunk.Forms == nil {
+			chunk.Forms = make(map[DisplayForm]int)
+		}
+		chunk.Forms[form]++
+	}
+}
+
+// SubChunk removes the forms of a chunk that left the window.
+func (d *DisplayForms) SubChunk(chunk *Chunk) {
+	d.Lock()
+	defer d.Unlock()
+
+	for form, count := range chunk.Forms {
+		forms := d.forms[form.Key]
+		if _, found := forms[form.Form]; !found {
+			// it didn't fit when the chunk was restored
+			continue
+		}
+		forms[form.Form] -= count
+		if forms[form.Form] <= 0 {
+			delete(forms, form.Form)
+			d.size--
+		}
+		if len(forms) == 0 {
+			delete(d.forms, form.Key)
+		}
+	}
+}
+
+// AddChunk adds the forms of a chunk back, e.g. when the window is restored from a backup.
+func (d *DisplayForms) AddChunk(chunk *Chunk) {
+	d.Lock()
+	defer d.Unlock()
+
+	for form, count := range chunk.Forms {
+		d.addUnlocked(form, count)
+	}
+}
+
+// Display returns the most common way key is written, given that it was used count times in total.
+func (d *DisplayForms) Display(key string, count int) string {
+	d.Lock()
+	defer d.Unlock()
+
+	display := key
+	best := count
+	for _, formCount := range d.forms[key] {
+		best -= formCount
+	}
+	// the lowercase form wins ties
+	for form, formCount := range d.forms[key] {
+		if formCount > best || (formCount == best && display != key && form < display) {
+			display, best = form, formCount
+		}
+	}
+
+	return display
+}
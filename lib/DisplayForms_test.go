@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestDisplayFormsDisplay(t *testing.T) {
+	tests := []struct {
+		name  string
+		forms map[string]int
+		// the word's total count, including the lowercase uses
+		count int
+		want  string
+	}{
+		{"never written differently", nil, 10, "nasa"},
+		{"unknown word", nil, 0, "nasa"},
+		{"mostly upper case", map[string]int{"NASA": 7}, 10, "NASA"},
+		{"mostly lower case", map[string]int{"NASA": 3}, 10, "nasa"},
+		{"lower case wins a tie", map[string]int{"NASA": 5}, 10, "nasa"},
+		{"the most common form wins", map[string]int{"NASA": 3, "Nasa": 5}, 10, "Nasa"},
+		{"ties between forms are broken the same way every time", map[string]int{"NASA": 4, "Nasa": 4}, 10, "NASA"},
+		{"never lower case", map[string]int{"NASA": 3, "Nasa": 2}, 5, "NASA"},
+	}
+
+	for _, test := range tests {
+		d := NewDisplayForms(100)
+		chunk := &Chunk{}
+		for form, count := range test.forms {
+			for i := 0; i < count; i++ {
+				d.CountTweet([]string{"nasa"}, []string{form}, chunk)
+			}
+		}
+
+		if got := d.Display("nasa", test.count); got != test.want {
+			t.Errorf("%s: Display = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDisplayFormsSubChunk(t *testing.T) {
+	d := NewDisplayForms(100)
+	old, current := &Chunk{}, &Chunk{}
+	for i := 0; i < 5; i++ {
+		d.CountTweet([]string{"nasa"}, []string{"NASA"}, old)
+	}
+	d.CountTweet([]string{"nasa"}, []string{"Nasa"}, current)
+
+	if got := d.Display("nasa", 6); got != "NASA" {
+		t.Errorf("Display = %q, want %q", got, "NASA")
+	}
+	d.SubChunk(old)
+	if got := d.Display("nasa", 1); got != "Nasa" {
+		t.Errorf("Display after the old chunk left = %q, want %q", got, "Nasa")
+	}
+	d.SubChunk(current)
+	if got := d.Display("nasa", 0); got != "nasa" {
+		t.Errorf("Display after every chunk left = %q, want %q", got, "nasa")
+	}
+	if d.size != 0 || len(d.forms) != 0 {
+		t.Errorf("%d forms left after every chunk left", d.size)
+	}
+}
+
+func TestDisplayFormsMaxForms(t *testing.T) {
+	d := NewDisplayForms(1)
+	chunk := &Chunk{}
+	d.CountTweet([]string{"nasa", "iphone"}, []string{"NASA", "iPhone"}, chunk)
+
+	if got := d.Display("nasa", 1); got != "NASA" {
+		t.Errorf("Display(nasa) = %q, want %q", got, "NASA")
+	}
+	// there was no room for it
+	if got := d.Display("iphone", 1); got != "iphone" {
+		t.Errorf("Display(iphone) = %q, want %q", got, "iphone")
+	}
+	if _, found := chunk.Forms[DisplayForm{Key: "iphone", Form: "iPhone"}]; found {
+		t.Errorf("the chunk has a form that wasn't counted")
+	}
+}
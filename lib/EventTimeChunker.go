@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"sort"
+	"time"
+)
+
+// OpenChunk is a chunk that still accepts tweets: Chunk has its metadata so far, and Words its counts.
+type OpenChunk struct {
+	Start time.Time
+	Chunk *Chunk
+	Words *IdDiff
+}
+
+// EventTimeChunker assigns tweets to chunks by when they were created instead of when they arrived.
+// Chunks stay open until the watermark (the latest creation time seen, minus AllowedLateness) passes their end,
+// so tweets that arrive a little out of order are still counted in the right chunk. Tweets for chunks that were
+// already sealed are dropped. Since only creation times move the watermark, replaying an archive produces the
+// same chunks as the live run did.
+type EventTimeChunker struct {
+	Period          time.Duration
+	AllowedLateness time.Duration
+	Watermark       time.Time
+	// chunks before this are sealed
+	sealedUntil time.Time
+	open        map[int64]*OpenChunk
+	// late tweets since the last sealed chunk
+	dropped int64
+	// at most this many empty chunks are produced for a stretch of time without tweets
+	maxEmpty int
+}
+
+func NewEventTimeChunker(period time.Duration, allowedLateness time.Duration, maxEmpty int) *EventTimeChunker {
+	c := &EventTimeChunker{}
+	c.Period = period
+	c.AllowedLateness = allowedLateness
+	c.open = make(map[int64]*OpenChunk)
+	c.maxEmpty = maxEmpty
+
+	return c
+}
+
+func (c *EventTimeChunker) openChunk(start time.Time) *OpenChunk {
+	open, ok := c.open[start.UnixNano()]
+	if !ok {
+		open = &OpenChunk{Start: start, Chunk: &Chunk{}, Words: NewIdDiff()}
+		c.open[start.UnixNano()] = open
+	}
+
+	return open
+}
+
+// Assign returns the open chunk a tweet created at createdAt belongs to,
+// or nil if that chunk was already sealed, in which case the tweet is counted as dropped.
+func (c *EventTimeChunker) Assign(createdAt time.Time) *OpenChunk {
+	start := createdAt.Truncate(c.Period)
+	if !c.sealedUntil.IsZero() && start.Before(c.sealedUntil) {
+		c.dropped++
+		return nil
+	}
+
+	open := c.openChunk(start)
+	open.Chunk.Tweets++
+	open.Chunk.AddCreatedAt(createdAt)
+
+	return open
+}
+
+// Advance moves the watermark for a tweet created at createdAt, and returns the chunks that are complete
+// now, oldest first. Periods without any tweets are returned as empty chunks, so the chunks stay evenly spaced.
+// The late tweets dropped since the last call are added to the first chunk's Dropped.
+func (c *EventTimeChunker) Advance(createdAt time.Time) []*OpenChunk {
+	watermark := createdAt.Add(-c.AllowedLateness)
+	if !watermark.After(c.Watermark) {
+		return nil
+	}
+	c.Watermark = watermark
+
+	// every chunk that ends before the watermark can be sealed
+	sealUntil := watermark.Truncate(c.Period)
+	if c.sealedUntil.IsZero() {
+		// nothing has been sealed yet, so start from the oldest open chunk
+		for _, open := range c.open {
+			if c.sealedUntil.IsZero() || open.Start.Before(c.sealedUntil) {
+				c.sealedUntil = open.Start
+			}
+		}
+		if c.sealedUntil.IsZero() {
+			c.sealedUntil = sealUntil
+		}
+	}
+	if !sealUntil.After(c.sealedUntil) {
+		return nil
+	}
+
+	// after a long stretch without tweets, skip the empty chunks nobody would see anyway
+	var sealed []*OpenChunk
+	start := c.sealedUntil
+	if skipTo := sealUntil.Add(-time.Duration(c.maxEmpty) * c.Period); start.Before(skipTo) {
+		start = skipTo
+	}
+	for ; start.Before(sealUntil); start = start.Add(c.Period) {
+		sealed = append(sealed, c.openChunk(start))
+		delete(c.open, start.UnixNano())
+	}
+	// the chunks with tweets from before the empty ones we skipped
+	for key, open := range c.open {
+		if open.Start.Before(sealUntil) {
+			sealed = append(sealed, open)
+			delete(c.open, key)
+		}
+	}
+	sort.Slice(sealed, func(i, j int) bool { return sealed[i].Start.Before(sealed[j].Start) })
+	c.sealedUntil = sealUntil
+
+	if len(sealed) > 0 {
+		sealed[0].Chunk.Dropped += c.dropped
+		c.dropped = 0
+	}
+
+	return sealed
+}
@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// Gap is a stretch of time where we got far fewer tweets than usual, e.g. because the stream was down.
+type Gap struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// how many tweets we got during the gap, and how many we would have at the usual rate
+	Tweets         int64   `json:"tweets"`
+	ExpectedTweets float64 `json:"expectedTweets"`
+	// the gap has not ended yet
+	Ongoing bool `json:"ongoing"`
+}
+
+// GapDetector watches the tweet count of every chunk. A chunk with less than MinRatio of the usual count
+// (an exponential moving average over the chunks that were not gaps) is part of a gap.
+type GapDetector struct {
+	MinRatio float64
+	// weight of the latest chunk in the moving average
+	Alpha float64
+	// the usual number of tweets per chunk
+	Rate    float64
+	Current *Gap
+	// the most recent gaps that ended, oldest first
+	Gaps    []Gap
+	MaxGaps int
+	// whether a chunk was observed since the process started. Not part of the backups.
+	observed bool
+	mutex    sync.Mutex
+}
+
+func NewGapDetector(minRatio float64, alpha float64, maxGaps int) *GapDetector {
+	d := &GapDetector{}
+	d.MinRatio = minRatio
+	d.Alpha = alpha
+	d.MaxGaps = maxGaps
+
+	return d
+}
+
+func (d *GapDetector) Lock() {
+	d.mutex.Lock()
+}
+
+func (d *GapDetector) Unlock() {
+	d.mutex.Unlock()
+}
+
+// Observe records a chunk covering [start, end) with the given number of tweets, and returns whether it is part of a gap.
+// The first chunk after the process starts is skipped, since it only has the tweets from after the start.
+func (d *GapDetector) Observe(start time.Time, end time.Time, tweets int64) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.observed {
+		d.observed = true
+		return false
+	}
+	if d.Rate == 0 {
+		d.Rate = float64(tweets)
+		return false
+	}
+
+	if float64(tweets) < d.MinRatio*d.Rate {
+		if d.Current == nil {
+			d.Current = &Gap{Start: start, Ongoing: true}
+		}
+		d.Current.End = end
+		d.Current.Tweets += tweets
+		d.Current.ExpectedTweets += d.Rate
+		// the moving average is left alone, so an outage doesn't become the new normal
+		return true
+	}
+
+	if d.Current != nil {
+		d.Current.Ongoing = false
+		d.Gaps = append(d.Gaps, *d.Current)
+		if len(d.Gaps) > d.MaxGaps {
+			d.Gaps = d.Gaps[len(d.Gaps)-d.MaxGaps:]
+		}
+		d.Current = nil
+	}
+	d.Rate = d.Alpha*float64(tweets) + (1-d.Alpha)*d.Rate
+
+	return false
+}
+
+// Recent returns the gaps that ended after since, including the ongoing one, oldest first.
+func (d *GapDetector) Recent(since time.Time) []Gap {
+	d.Lock()
+	defer d.Unlock()
+
+	gaps := make([]Gap, 0)
+	for _, gap := range d.Gaps {
+		if gap.End.After(since) {
+			gaps = append(gaps, gap)
+		}
+	}
+	if d.Current != nil {
+		gaps = append(gaps, *d.Current)
+	}
+
+	return gaps
+}
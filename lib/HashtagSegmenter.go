@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// HashtagSegmenter splits hashtags into the words they are made of, e.g. #BlackFriday2024Deals into black, friday,
+// 2024 and deals. Case changes, digits and underscores split the hashtag first, and every run of letters that is
+// long enough is then split again with a unigram model: the split whose words are the most likely, given how often
+// each one is normally used, wins. That takes care of lowercase hashtags like #blackfriday.
+// The segmentations are cached, up to MaxCached hashtags, since the same hashtags come up over and over.
+type HashtagSegmenter struct {
+	// the rate of words that have never been seen. Longer unknown words are even less likely.
+	MinRate float64
+	// the longest word, in runes, a run of letters is split into
+	MaxWordLength int
+	// runs of letters shorter than this are left alone
+	MinSplitLength int
+	MaxCached      int
+	cache          map[string][]string
+	mutex          sync.Mutex
+}
+
+func NewHashtagSegmenter(minRate float64, maxWordLength int, minSplitLength int, maxCached int) *HashtagSegmenter {
+	h := &HashtagSegmenter{}
+	h.MinRate = minRate
+	h.MaxWordLength = maxWordLength
+	h.MinSplitLength = minSplitLength
+	h.MaxCached = maxCached
+	h.cache = make(map[string][]string)
+
+	return h
+}
+
+// Reset forgets the cached segmentations, e.g. once the rates have changed.
+func (h *HashtagSegmenter) Reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.cache = make(map[string][]string)
+}
+
+// splitHashtag splits a hashtag (without the #) on case changes, digits and underscores.
+// A run of capitals belongs to the word after it only with its last letter, e.g. NASALaunch is NASA and Launch.
+func splitHashtag(tag string) []string {
+	runes := []rune(tag)
+	var pieces []string
+	start := 0
+	for i := 1; i <= len(runes); i++ {
+		boundary := i == len(runes) || runes[i] == '_'
+		if !boundary {
+			prev, r := runes[i-1], runes[i]
+			boundary = (unicode.IsLower(prev) && unicode.IsUpper(r)) ||
+				(unicode.IsLetter(prev) && unicode.IsDigit(r)) ||
+				(unicode.IsDigit(prev) && unicode.IsLetter(r)) ||
+				(unicode.IsUpper(prev) && unicode.IsUpper(r) && i+1 < len(runes) && unicode.IsLower(runes[i+1]))
+		}
+		if !boundary {
+			continue
+		}
+		if runes[start] == '_' {
+			start++
+		}
+		if start < i {
+			pieces = append(pieces, strings.ToLower(string(runes[start:i])))
+		}
+		start = i
+	}
+
+	return pieces
+}
+
+// cost is the negative log likelihood of word
+func (h *HashtagSegmenter) cost(word []rune, rate func(string) float64) float64 {
+	if r := rate(string(word)); r > h.MinRate {
+		return -math.Log(r)
+	}
+
+	return -math.Log(h.MinRate) + float64(len(word)-1)*math.Ln10
+}
+
+// splitWords finds the most likely way to split a run of letters into words.
+func (h *HashtagSegmenter) splitWords(piece string, rate func(string) float64) []string {
+	runes := []rune(piece)
+	// best[i] is the cost of the best split of runes[:i], whose last word starts at from[i]
+	best := make([]float64, len(runes)+1)
+	from := make([]int, len(runes)+1)
+	for i := 1; i <= len(runes); i++ {
+		best[i] = math.Inf(1)
+		for j := i - 1; j >= 0 && i-j <= h.MaxWordLength; j-- {
+			cost := best[j] + h.cost(runes[j:i], rate)
+			if cost < best[i] {
+				best[i], from[i] = cost, j
+			}
+		}
+	}
+
+	var words []string
+	for i := len(runes); i > 0; i = from[i] {
+		words = append(words, string(runes[from[i]:i]))
+	}
+	for i, j := 0, len(words)-1; i < j; i, j = i+1, j-1 {
+		words[i], words[j] = words[j], words[i]
+	}
+
+	return words
+}
+
+// Segment returns the lowercase words hashtag (with or without the #) is made of, or nil if it is just one word.
+// rate returns how many times a word is normally used per tweet.
+func (h *HashtagSegmenter) Segment(hashtag string, rate func(string) float64) []string {
+	tag := strings.TrimPrefix(hashtag, "#")
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if words, found := h.cache[tag]; found {
+		return words
+	}
+
+	var words []string
+	for _, piece := range splitHashtag(tag) {
+		runes := []rune(piece)
+		if unicode.IsLetter(runes[0]) && len(runes) >= h.MinSplitLength {
+			words = append(words, h.splitWords(piece, rate)...)
+		} else {
+			words = append(words, piece)
+		}
+	}
+	if len(words) == 1 && words[0] == strings.ToLower(tag) {
+		words = nil
+	}
+
+	// the rates drift, so starting over once in a while is fine
+	if len(h.cache) >= h.MaxCached {
+		h.cache = make(map[string][]string)
+	}
+	h.cache[tag] = words
+
+	return words
+}
@@ -0,0 +1,92 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitHashtag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want []string
+	}{
+		{"", nil},
+		{"blackfriday", []string{"blackfriday"}},
+		{"BlackFriday", []string{"black", "friday"}},
+		{"BlackFriday2024Deals", []string{"black", "friday", "2024", "deals"}},
+		{"NASALaunch", []string{"nasa", "launch"}},
+		{"COVID19", []string{"covid", "19"}},
+		{"iPhone", []string{"i", "phone"}},
+		{"world_cup", []string{"world", "cup"}},
+		{"__a__", []string{"a"}},
+		{"ÉtéParis", []string{"été", "paris"}},
+	}
+
+	for _, test := range tests {
+		got := splitHashtag(test.tag)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitHashtag(%q) = %q, want %q", test.tag, got, test.want)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	rates := map[string]float64{
+		"black":   1e-3,
+		"friday":  1e-3,
+		"deals":   5e-4,
+		"new":     2e-3,
+		"york":    5e-4,
+		"newyork": 1e-5,
+	}
+	rate := func(word string) float64 { return rates[word] }
+	h := NewHashtagSegmenter(1e-7, 20, 6, 100)
+
+	tests := []struct {
+		piece string
+		want  []string
+	}{
+		{"black", []string{"black"}},
+		{"blackfriday", []string{"black", "friday"}},
+		{"blackfridaydeals", []string{"black", "friday", "deals"}},
+		// an unknown word is cheaper than splitting it into letters
+		{"xyz", []string{"xyz"}},
+		{"blackxyz", []string{"black", "xyz"}},
+		// a word that is used on its own is more likely than the two words it is made of
+		{"newyork", []string{"newyork"}},
+		{"newyorkdeals", []string{"newyork", "deals"}},
+	}
+
+	for _, test := range tests {
+		got := h.splitWords(test.piece, rate)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", test.piece, got, test.want)
+		}
+	}
+}
+
+func TestSegment(t *testing.T) {
+	rates := map[string]float64{"black": 1e-3, "friday": 1e-3}
+	rate := func(word string) float64 { return rates[word] }
+	h := NewHashtagSegmenter(1e-7, 20, 6, 100)
+
+	tests := []struct {
+		hashtag string
+		want    []string
+	}{
+		{"#blackfriday", []string{"black", "friday"}},
+		{"#BlackFriday", []string{"black", "friday"}},
+		{"blackfriday", []string{"black", "friday"}},
+		// one word isn't split into anything
+		{"#black", nil},
+		{"#Black", nil},
+		{"#Friday13", []string{"friday", "13"}},
+	}
+
+	for _, test := range tests {
+		got := h.Segment(test.hashtag, rate)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Segment(%q) = %q, want %q", test.hashtag, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log"
+	"sync"
+)
+
+// IdDiff is a WordDiff keyed by symbol IDs from Symbols instead of strings.
+// It holds one reference on every ID it contains, which is dropped by Release.
+type IdDiff struct {
+	Words map[uint32]int
+	// Maps do not allow concurrent reads and writes in Go, so we must use a mutex
+	mutex sync.Mutex
+}
+
+func NewIdDiff() *IdDiff {
+	w := &IdDiff{}
+	w.Words = make(map[uint32]int)
+
+	return w
+}
+
+func (w *IdDiff) Lock() {
+	w.mutex.Lock()
+}
+
+func (w *IdDiff) Unlock() {
+	w.mutex.Unlock()
+}
+
+func (w *IdDiff) IncWord(word string) {
+	w.Lock()
+	defer w.Unlock()
+
+	id := Symbols.Intern(word)
+	count, ok := w.Words[id]
+	if !ok {
+		w.Words[id] = 1
+	} else {
+		// we already hold a reference for this word
+		Symbols.Release(id)
+		w.Words[id] = count + 1
+	}
+}
+
+// AddWord increments word by count, taking a reference on it if this diff doesn't hold one yet.
+func (w *IdDiff) AddWord(word string, count int) {
+	w.Lock()
+	defer w.Unlock()
+
+	id := Symbols.Intern(word)
+	current, ok := w.Words[id]
+	if ok {
+		Symbols.Release(id)
+	}
+	w.Words[id] = current + count
+}
+
+func (w *IdDiff) GetUnlocked(id uint32) int {
+	count, ok := w.Words[id]
+	if !ok {
+		count = 0
+	}
+
+	return count
+}
+
+func (w *IdDiff) Get(word string) int {
+	id, ok := Symbols.Lookup(word)
+	if !ok {
+		return 0
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	return w.GetUnlocked(id)
+}
+
+func (w *IdDiff) WalkUnlocked(walkFunc func(uint32, int)) {
+	for id, count := range w.Words {
+		walkFunc(id, count)
+	}
+}
+
+func (w *IdDiff) Walk(walkFunc func(uint32, int)) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.WalkUnlocked(walkFunc)
+}
+
+// Release drops the references this diff holds in Symbols. The diff must not be used afterwards.
+func (w *IdDiff) Release() {
+	w.Lock()
+	defer w.Unlock()
+
+	Symbols.Lock()
+	defer Symbols.Unlock()
+	for id := range w.Words {
+		Symbols.releaseUnlocked(id)
+	}
+	w.Words = make(map[uint32]int)
+}
+
+// ToWordDiff resolves every ID back into its word.
+func (w *IdDiff) ToWordDiff() *WordDiff {
+	diff := NewWordDiff()
+
+	w.Lock()
+	defer w.Unlock()
+	Symbols.Lock()
+	defer Symbols.Unlock()
+	for id, count := range w.Words {
+		diff.Words[Symbols.WordUnlocked(id)] = count
+	}
+
+	return diff
+}
+
+// SubFrom subtracts this diff's counts from the word keyed diff.
+func (w *IdDiff) SubFrom(diff *WordDiff) {
+	w.Lock()
+	diff.Lock()
+	defer w.Unlock()
+	defer diff.Unlock()
+	Symbols.Lock()
+	defer Symbols.Unlock()
+
+	for id, count := range w.Words {
+		word := Symbols.WordUnlocked(id)
+		diff.Words[word] = diff.GetUnlocked(word) - count
+	}
+}
+
+func (w *IdDiff) Serialize() []byte {
+	w.Lock()
+	defer w.Unlock()
+	buffer := bytes.NewBuffer([]byte{})
+	encoder := gob.NewEncoder(buffer)
+	err := encoder.Encode(w)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+)
+
+// WordMomentum is how fast a word's rate is changing over the last few spans of chunks.
+// Rates are uses per 1000 tweets.
+type WordMomentum struct {
+	Word string `json:"word"`
+	// the rate in the latest span
+	Rate float64 `json:"rate"`
+	// change in rate from the previous span to the latest one
+	Velocity float64 `json:"velocity"`
+	// change in velocity, i.e. whether the word is speeding up or slowing down
+	Acceleration float64 `json:"acceleration"`
+	// how many standard deviations the latest rate is from the previous one, under a Poisson model.
+	// Positive when rising, negative when falling.
+	Score       float64 `json:"score"`
+	Explanation string  `json:"explanation"`
+}
+
+type momentumSpan struct {
+	counts map[uint32]int
+	tweets int64
+}
+
+func (s *momentumSpan) rate(id uint32) float64 {
+	if s.tweets == 0 {
+		return 0
+	}
+
+	return float64(s.counts[id]) / float64(s.tweets)
+}
+
+// Momentum splits the last 3*span chunks into three spans and computes the velocity and acceleration of every word
+// that was used at least minCount times in the last two. Only comparing the last two spans to each other makes
+// it independent of the baseline, so it catches words that are taking off before they stand out against it.
+// The chunks must be retained (see Chunk.Retain), or their IDs may already belong to other words.
+func Momentum(chunks []*Chunk, span int, minCount int) []WordMomentum {
+	if len(chunks) > 3*span {
+		chunks = chunks[len(chunks)-3*span:]
+	}
+	// the oldest span is the one that may come up short
+	spans := make([]momentumSpan, 3)
+	for i := range chunks {
+		s := &spans[2-(len(chunks)-1-i)/span]
+		if s.counts == nil {
+			s.counts = make(map[uint32]int)
+		}
+		s.tweets += chunks[i].Tweets
+		if chunks[i].Words != nil {
+			chunks[i].Words.Walk(func(id uint32, count int) {
+				s.counts[id] += count
+			})
+		}
+	}
+	previous, latest := &spans[1], &spans[2]
+	if previous.tweets == 0 || latest.tweets == 0 {
+		return make([]WordMomentum, 0)
+	}
+
+	candidates := make(map[uint32]bool)
+	for _, s := range []*momentumSpan{previous, latest} {
+		for id := range s.counts {
+			if latest.counts[id]+previous.counts[id] >= minCount {
+				candidates[id] = true
+			}
+		}
+	}
+
+	Symbols.Lock()
+	defer Symbols.Unlock()
+
+	momentum := make([]WordMomentum, 0, len(candidates))
+	for id := range candidates {
+		word := Symbols.WordUnlocked(id)
+		if word == "" {
+			continue
+		}
+
+		r1, r2, r3 := spans[0].rate(id), previous.rate(id), latest.rate(id)
+		// variance of the difference of two Poisson rates
+		variance := r3/float64(latest.tweets) + r2/float64(previous.tweets)
+		score := (r3 - r2) / math.Sqrt(variance)
+		acceleration := (r3 - r2) - (r2 - r1)
+		if spans[0].tweets == 0 {
+			acceleration = 0
+		}
+
+		momentum = append(momentum, WordMomentum{
+			Word:         word,
+			Rate:         r3 * 1000,
+			Velocity:     (r3 - r2) * 1000,
+			Acceleration: acceleration * 1000,
+			Score:        score,
+			Explanation: fmt.Sprintf("%d uses in %d tweets, after %d in %d and %d in %d before that",
+				latest.counts[id], latest.tweets, previous.counts[id], previous.tweets, spans[0].counts[id], spans[0].tweets),
+		})
+	}
+
+	return momentum
+}
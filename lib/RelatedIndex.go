@@ -0,0 +1,203 @@
+package lib
+
+import (
+	"container/heap"
+	"container/list"
+	"math"
+	"sort"
+	"sync"
+)
+
+// RelatedWord is a word that is used together with another one.
+type RelatedWord struct {
+	Word string `json:"word"`
+	// about how many recent tweets used both words
+	Count float64 `json:"count"`
+	// how many times more likely the word is in tweets with the other word than in tweets in general
+	Lift float64 `json:"lift"`
+	// log2 of the lift
+	PMI float64 `json:"pmi"`
+}
+
+type relatedNeighbor struct {
+	word  string
+	count float64
+	index int
+}
+
+// a min heap by count, so the neighbor to replace is always on top
+type relatedNeighborHeap []*relatedNeighbor
+
+func (h relatedNeighborHeap) Len() int           { return len(h) }
+func (h relatedNeighborHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h relatedNeighborHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *relatedNeighborHeap) Push(x interface{}) {
+	neighbor := x.(*relatedNeighbor)
+	neighbor.index = len(*h)
+	*h = append(*h, neighbor)
+}
+func (h *relatedNeighborHeap) Pop() interface{} {
+	old := *h
+	neighbor := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return neighbor
+}
+
+type relatedEntry struct {
+	word string
+	// decayed number of tweets that used the word
+	tweets    float64
+	neighbors map[string]*relatedNeighbor
+	smallest  relatedNeighborHeap
+	// the chunk the counts were last decayed to
+	stamp   uint64
+	element *list.Element
+}
+
+// RelatedIndex counts which words recent tweets use together, for any word, in bounded memory.
+// At most MaxWords words are tracked, dropping the least recently used one when a new word comes in, and each keeps
+// at most MaxNeighbors neighbors the way SpaceSaving does: a new neighbor replaces the smallest one and takes over its
+// count, so counts can be a bit too high but the frequent neighbors are never lost. The neighbors are kept in a min
+// heap, so finding the smallest one doesn't mean scanning all of them for every pair of every tweet.
+// Instead of subtracting chunks when they leave the focus window, counts decay by half every HalfLife chunks.
+// The decay is applied lazily when a word is touched, so idle words cost nothing.
+type RelatedIndex struct {
+	MaxWords     int
+	MaxNeighbors int
+	HalfLife     float64
+	// used as the rate of words the baseline has never seen
+	MinRate float64
+	words   map[string]*relatedEntry
+	// least recently used at the back
+	lru   *list.List
+	mutex sync.Mutex
+}
+
+func NewRelatedIndex(maxWords int, maxNeighbors int, halfLife float64, minRate float64) *RelatedIndex {
+	r := &RelatedIndex{}
+	r.MaxWords = maxWords
+	r.MaxNeighbors = maxNeighbors
+	r.HalfLife = halfLife
+	r.MinRate = minRate
+	r.words = make(map[string]*relatedEntry)
+	r.lru = list.New()
+
+	return r
+}
+
+func (r *RelatedIndex) decayUnlocked(entry *relatedEntry, now uint64) {
+	if now <= entry.stamp {
+		return
+	}
+
+	factor := math.Pow(0.5, float64(now-entry.stamp)/r.HalfLife)
+	entry.tweets *= factor
+	// every count shrinks by the same factor, so the heap stays in order
+	for _, neighbor := range entry.smallest {
+		neighbor.count *= factor
+	}
+	entry.stamp = now
+}
+
+func (r *RelatedIndex) touchUnlocked(word string, now uint64) *relatedEntry {
+	entry, ok := r.words[word]
+	if ok {
+		r.lru.MoveToFront(entry.element)
+		r.decayUnlocked(entry, now)
+		return entry
+	}
+
+	if len(r.words) >= r.MaxWords {
+		oldest := r.lru.Remove(r.lru.Back()).(*relatedEntry)
+		delete(r.words, oldest.word)
+	}
+	entry = &relatedEntry{word: word, neighbors: make(map[string]*relatedNeighbor), stamp: now}
+	entry.element = r.lru.PushFront(entry)
+	r.words[word] = entry
+
+	return entry
+}
+
+func (r *RelatedIndex) addNeighborUnlocked(entry *relatedEntry, word string) {
+	if neighbor, ok := entry.neighbors[word]; ok {
+		neighbor.count++
+		heap.Fix(&entry.smallest, neighbor.index)
+		return
+	}
+	if len(entry.smallest) < r.MaxNeighbors {
+		neighbor := &relatedNeighbor{word: word, count: 1}
+		entry.neighbors[word] = neighbor
+		heap.Push(&entry.smallest, neighbor)
+		return
+	}
+
+	// the new word takes over the smallest neighbor and its count
+	smallest := entry.smallest[0]
+	delete(entry.neighbors, smallest.word)
+	smallest.word = word
+	smallest.count++
+	entry.neighbors[word] = smallest
+	heap.Fix(&entry.smallest, 0)
+}
+
+// CountTweet counts the words of one tweet, made during chunk now, and every pair of them.
+// Only the first maxWords distinct words are paired, so a very long tweet can't take over.
+func (r *RelatedIndex) CountTweet(words []string, now uint64, maxWords int) {
+	distinct := make([]string, 0, len(words))
+	seen := make(map[string]bool, len(words))
+	for _, word := range words {
+		if !seen[word] && len(distinct) < maxWords {
+			seen[word] = true
+			distinct = append(distinct, word)
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entries := make([]*relatedEntry, len(distinct))
+	for i, word := range distinct {
+		entries[i] = r.touchUnlocked(word, now)
+		entries[i].tweets++
+	}
+	for i := range entries {
+		for j := range entries {
+			if i != j {
+				r.addNeighborUnlocked(entries[i], distinct[j])
+			}
+		}
+	}
+}
+
+// Related returns the words used with word at least minCount times, best lift first, and how many recent tweets
+// used word. rate returns how many times a word is normally used per tweet, and is called without the index locked.
+func (r *RelatedIndex) Related(word string, now uint64, minCount float64, rate func(string) float64) ([]RelatedWord, float64, bool) {
+	r.mutex.Lock()
+	entry, ok := r.words[word]
+	if !ok {
+		r.mutex.Unlock()
+		return make([]RelatedWord, 0), 0, false
+	}
+	r.decayUnlocked(entry, now)
+	tweets := entry.tweets
+	related := make([]RelatedWord, 0, len(entry.neighbors))
+	for _, neighbor := range entry.smallest {
+		if neighbor.count >= minCount {
+			related = append(related, RelatedWord{Word: neighbor.word, Count: neighbor.count})
+		}
+	}
+	r.mutex.Unlock()
+
+	for i := range related {
+		neighborRate := math.Max(rate(related[i].Word), r.MinRate)
+		related[i].Lift = related[i].Count / tweets / neighborRate
+		related[i].PMI = math.Log2(related[i].Lift)
+	}
+	sort.Slice(related, func(i, j int) bool { return related[i].Lift > related[j].Lift })
+
+	return related, tweets, true
+}
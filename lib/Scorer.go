@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"fmt"
+)
+
+// ScoreInput is what a Scorer knows about a word in the window being ranked.
+type ScoreInput struct {
+	Word string
+	// how many times the word was used in the window
+	Count int
+	// how many tweets the window covers
+	WindowTweets int64
+	// how many times the word is normally used per tweet, according to the baseline
+	LongRate float64
+	// the chunks the window is made of, oldest first, if the scorer wants to look at how the word is spread out.
+	// nil for windows that aren't made of chunks.
+	Chunks []*Chunk
+}
+
+// Expected is how many times we would expect to see the word in the window at its normal rate.
+func (in ScoreInput) Expected() float32 {
+	return float32(in.LongRate * float64(in.WindowTweets))
+}
+
+// WordScore is the result of scoring one word. Higher scores rank higher.
+type WordScore struct {
+	Score float32
+	// the count above what the baseline expects
+	AdjustedCount int
+	// how many times more often the word was used than expected
+	Multiple float32
+}
+
+// Scorer ranks the words of a window against the baseline. getTop calls Score for every word of the window,
+// so it should be cheap, and only calls Explain for the words that made the top list.
+type Scorer interface {
+	// Score returns false if the word should not be ranked at all.
+	Score(in ScoreInput) (WordScore, bool)
+	// Explain says how Score came up with the score, for people tuning the ranking.
+	Explain(in ScoreInput, score WordScore) string
+}
+
+// MultipleScorer is the original formula: half of the score is how many times more often than usual the word
+// was used (between MinMultiple and MaxMultiple), and the other half is its count (up to MaxAdjustedCount).
+// MinCount and MaxAdjustedCount were tuned on windows of ThresholdTweets tweets, and are scaled to the window.
+type MultipleScorer struct {
+	MinMultiple      float32
+	MaxMultiple      float32
+	MinCount         float32
+	MaxAdjustedCount float32
+	ThresholdTweets  float32
+}
+
+func (s *MultipleScorer) scale(in ScoreInput) float32 {
+	return float32(in.WindowTweets) / s.ThresholdTweets
+}
+
+func (s *MultipleScorer) Score(in ScoreInput) (WordScore, bool) {
+	scale := s.scale(in)
+	windowMinCount := s.MinCount * scale
+	windowMaxAdjustedCount := s.MaxAdjustedCount * scale
+
+	// if the count is already below the minCount, don't bother
+	if in.Count < int(windowMinCount) || in.LongRate == 0 {
+		return WordScore{}, false
+	}
+
+	expectedCount := in.Expected()
+	var multiple float32
+	if expectedCount < 1 {
+		multiple = s.MaxMultiple
+	} else {
+		multiple = float32(in.Count) / expectedCount
+	}
+
+	adjustedCount := in.Count - int(expectedCount)
+	if adjustedCount <= int(windowMinCount) || multiple <= s.MinMultiple {
+		return WordScore{}, false
+	}
+
+	// secret sauce formula. maybe change some of these values to be more empirical and based on statistics.
+	score := (minFloat32(multiple-s.MinMultiple, s.MaxMultiple)/s.MaxMultiple)*0.5 +
+		minFloat32(float32(in.Count), windowMaxAdjustedCount)/windowMaxAdjustedCount*0.5
+
+	return WordScore{Score: score, AdjustedCount: adjustedCount, Multiple: multiple}, true
+}
+
+func (s *MultipleScorer) Explain(in ScoreInput, score WordScore) string {
+	windowMaxAdjustedCount := s.MaxAdjustedCount * s.scale(in)
+	multipleTerm := minFloat32(score.Multiple-s.MinMultiple, s.MaxMultiple) / s.MaxMultiple * 0.5
+	countTerm := minFloat32(float32(in.Count), windowMaxAdjustedCount) / windowMaxAdjustedCount * 0.5
+
+	return fmt.Sprintf("used %d times, %.1fx the expected %.1f: %.3f for the multiple + %.3f for the count (capped at %.0f)",
+		in.Count, score.Multiple, in.Expected(), multipleTerm, countTerm, windowMaxAdjustedCount)
+}
+
+func minFloat32(a, b float32) float32 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,260 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"log"
+	"sort"
+)
+
+// how many counts there are between two entries of the SealedChunk's offset index
+const sealedIndexStride = 32
+
+// SealedChunk is the frozen form of a chunk once it is pushed to the wordDiffQueue.
+// It never changes after it is sealed, so it does not need a mutex, and instead of a map it stores
+// sorted word IDs with their counts packed as varints right next to them. This is a lot smaller than a map
+// and has no pointers for the GC to scan.
+type SealedChunk struct {
+	ids []uint32
+	// uvarint encoded counts, in the same order as ids
+	counts []byte
+	// the offset in counts of every sealedIndexStride'th count, so Get doesn't have to decode from the start
+	offsets []uint32
+}
+
+// binary.AppendUvarint is not available in go 1.17
+func appendUvarint(data []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+
+	return append(data, buf[:n]...)
+}
+
+func newSealedChunk(words map[uint32]int) *SealedChunk {
+	s := &SealedChunk{}
+	s.ids = make([]uint32, 0, len(words))
+	for id := range words {
+		s.ids = append(s.ids, id)
+	}
+	sort.Slice(s.ids, func(i, j int) bool { return s.ids[i] < s.ids[j] })
+
+	s.counts = make([]byte, 0, len(words))
+	s.offsets = make([]uint32, 0, len(words)/sealedIndexStride+1)
+	for i, id := range s.ids {
+		if i%sealedIndexStride == 0 {
+			s.offsets = append(s.offsets, uint32(len(s.counts)))
+		}
+		s.counts = appendUvarint(s.counts, uint64(words[id]))
+	}
+
+	return s
+}
+
+// Seal freezes the diff into a SealedChunk. The references the diff held in Symbols now belong to the
+// sealed chunk, and the diff is left empty.
+func (w *IdDiff) Seal() *SealedChunk {
+	w.Lock()
+	defer w.Unlock()
+
+	s := newSealedChunk(w.Words)
+	w.Words = make(map[uint32]int)
+
+	return s
+}
+
+// Len returns the number of distinct words in the chunk.
+func (s *SealedChunk) Len() int {
+	return len(s.ids)
+}
+
+// GetId returns the count of the word with the given symbol ID.
+func (s *SealedChunk) GetId(id uint32) int {
+	i := sort.Search(len(s.ids), func(i int) bool { return s.ids[i] >= id })
+	if i == len(s.ids) || s.ids[i] != id {
+		return 0
+	}
+
+	offset := int(s.offsets[i/sealedIndexStride])
+	var count uint64
+	for j := i - i%sealedIndexStride; j <= i; j++ {
+		var n int
+		count, n = binary.Uvarint(s.counts[offset:])
+		offset += n
+	}
+
+	return int(count)
+}
+
+func (s *SealedChunk) Get(word string) int {
+	id, ok := Symbols.Lookup(word)
+	if !ok {
+		return 0
+	}
+
+	return s.GetId(id)
+}
+
+// Walk calls walkFunc for every word in the chunk, in order of increasing ID.
+func (s *SealedChunk) Walk(walkFunc func(uint32, int)) {
+	offset := 0
+	for _, id := range s.ids {
+		count, n := binary.Uvarint(s.counts[offset:])
+		offset += n
+		walkFunc(id, int(count))
+	}
+}
+
+// WalkWords is Walk, but with the IDs resolved through Symbols.
+// walkFunc must not call back into Symbols, since it is locked during the walk.
+func (s *SealedChunk) WalkWords(walkFunc func(string, int)) {
+	Symbols.Lock()
+	defer Symbols.Unlock()
+
+	s.Walk(func(id uint32, count int) {
+		walkFunc(Symbols.WordUnlocked(id), count)
+	})
+}
+
+// SubFrom subtracts this chunk's counts from the word keyed diff.
+func (s *SealedChunk) SubFrom(diff *WordDiff) {
+	diff.Lock()
+	defer diff.Unlock()
+
+	s.WalkWords(func(word string, count int) {
+		diff.Words[word] = diff.GetUnlocked(word) - count
+	})
+}
+
+// SubFromPruned is SubFrom, but also drops the words that reach 0. That is much cheaper than pruning the whole diff
+// after every subtraction.
+func (s *SealedChunk) SubFromPruned(diff *WordDiff) {
+	diff.Lock()
+	defer diff.Unlock()
+
+	s.WalkWords(func(word string, count int) {
+		left := diff.GetUnlocked(word) - count
+		if left <= 0 {
+			delete(diff.Words, word)
+		} else {
+			diff.Words[word] = left
+		}
+	})
+}
+
+// AddTo adds this chunk's counts to the word keyed diff.
+func (s *SealedChunk) AddTo(diff *WordDiff) {
+	diff.Lock()
+	defer diff.Unlock()
+
+	s.WalkWords(func(word string, count int) {
+		diff.Words[word] = diff.GetUnlocked(word) + count
+	})
+}
+
+// AddSealed merges a sealed chunk into the diff, taking references on the words that are new to it.
+func (w *IdDiff) AddSealed(s *SealedChunk) {
+	w.Lock()
+	defer w.Unlock()
+	Symbols.Lock()
+	defer Symbols.Unlock()
+
+	s.Walk(func(id uint32, count int) {
+		current, ok := w.Words[id]
+		if !ok {
+			Symbols.Refs[id]++
+		}
+		w.Words[id] = current + count
+	})
+}
+
+// ToWordDiff resolves every ID back into its word.
+func (s *SealedChunk) ToWordDiff() *WordDiff {
+	diff := NewWordDiff()
+	s.WalkWords(func(word string, count int) {
+		diff.Words[word] = count
+	})
+
+	return diff
+}
+
+// Release drops the references this chunk holds in Symbols. The chunk must not be used afterwards.
+func (s *SealedChunk) Release() {
+	Symbols.Lock()
+	defer Symbols.Unlock()
+
+	for _, id := range s.ids {
+		Symbols.releaseUnlocked(id)
+	}
+	s.ids = nil
+	s.counts = nil
+	s.offsets = nil
+}
+
+// MarshalBinary encodes the chunk as the number of words, the delta encoded IDs and then the counts.
+// Implementing it also means gob uses this format when the chunk is part of a backup.
+func (s *SealedChunk) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, binary.MaxVarintLen64+len(s.ids)*2+len(s.counts))
+	data = appendUvarint(data, uint64(len(s.ids)))
+	var last uint32
+	for _, id := range s.ids {
+		data = appendUvarint(data, uint64(id-last))
+		last = id
+	}
+	data = append(data, s.counts...)
+
+	return data, nil
+}
+
+func (s *SealedChunk) UnmarshalBinary(data []byte) error {
+	errCorrupt := errors.New("SealedChunk: corrupt binary data")
+
+	length, n := binary.Uvarint(data)
+	if n <= 0 {
+		return errCorrupt
+	}
+	data = data[n:]
+
+	ids := make([]uint32, length)
+	var last uint32
+	for i := range ids {
+		delta, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errCorrupt
+		}
+		data = data[n:]
+		last += uint32(delta)
+		ids[i] = last
+	}
+
+	offsets := make([]uint32, 0, len(ids)/sealedIndexStride+1)
+	offset := 0
+	for i := range ids {
+		if i%sealedIndexStride == 0 {
+			offsets = append(offsets, uint32(offset))
+		}
+		_, n := binary.Uvarint(data[offset:])
+		if n <= 0 {
+			return errCorrupt
+		}
+		offset += n
+	}
+
+	s.ids = ids
+	s.counts = append([]byte{}, data[:offset]...)
+	s.offsets = offsets
+
+	return nil
+}
+
+func (s *SealedChunk) Serialize() []byte {
+	buffer := bytes.NewBuffer([]byte{})
+	encoder := gob.NewEncoder(buffer)
+	err := encoder.Encode(s)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+func TestSealedChunkRoundTrip(t *testing.T) {
+	many := make(map[uint32]int)
+	for i := 0; i < 5*sealedIndexStride+3; i++ {
+		// gaps between the IDs, and counts of every varint length
+		many[uint32(i*7+1)] = []int{1, 127, 128, 300, 1 << 20, 1<<31 - 1}[i%6]
+	}
+
+	tests := []struct {
+		name  string
+		words map[uint32]int
+	}{
+		{"empty", map[uint32]int{}},
+		{"one word", map[uint32]int{42: 3}},
+		{"first ID", map[uint32]int{0: 1, 1: 2}},
+		{"large IDs", map[uint32]int{1<<32 - 2: 5, 1 << 31: 6, 7: 7}},
+		{"more than one stride", many},
+	}
+
+	for _, test := range tests {
+		sealed := newSealedChunk(test.words)
+
+		data, err := sealed.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: MarshalBinary failed: %v", test.name, err)
+		}
+		decoded := &SealedChunk{}
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%s: UnmarshalBinary failed: %v", test.name, err)
+		}
+
+		// gob goes through the same methods, as part of the backups
+		buffer := bytes.NewBuffer(sealed.Serialize())
+		gobbed := &SealedChunk{}
+		if err := gob.NewDecoder(buffer).Decode(gobbed); err != nil {
+			t.Fatalf("%s: gob decoding failed: %v", test.name, err)
+		}
+
+		for _, chunk := range []*SealedChunk{sealed, decoded, gobbed} {
+			if chunk.Len() != len(test.words) {
+				t.Errorf("%s: Len = %d, want %d", test.name, chunk.Len(), len(test.words))
+			}
+			for id, count := range test.words {
+				if got := chunk.GetId(id); got != count {
+					t.Errorf("%s: GetId(%d) = %d, want %d", test.name, id, got, count)
+				}
+				// IDs that aren't in the chunk
+				if _, found := test.words[id+1]; !found && chunk.GetId(id+1) != 0 {
+					t.Errorf("%s: GetId(%d) = %d, want 0", test.name, id+1, chunk.GetId(id+1))
+				}
+			}
+
+			var last uint32
+			walked := 0
+			chunk.Walk(func(id uint32, count int) {
+				if walked > 0 && id <= last {
+					t.Errorf("%s: Walk visited %d after %d", test.name, id, last)
+				}
+				if count != test.words[id] {
+					t.Errorf("%s: Walk gave %d a count of %d, want %d", test.name, id, count, test.words[id])
+				}
+				last = id
+				walked++
+			})
+			if walked != len(test.words) {
+				t.Errorf("%s: Walk visited %d words, want %d", test.name, walked, len(test.words))
+			}
+		}
+	}
+}
+
+func TestSealedChunkCorrupt(t *testing.T) {
+	sealed := newSealedChunk(map[uint32]int{1: 1, 300: 300, 70000: 2})
+	data, _ := sealed.MarshalBinary()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"no data", nil},
+		{"only the length", data[:1]},
+		{"cut off in the IDs", data[:3]},
+		{"cut off in the counts", data[:len(data)-1]},
+	}
+
+	for _, test := range tests {
+		if err := (&SealedChunk{}).UnmarshalBinary(test.data); err == nil {
+			t.Errorf("%s: UnmarshalBinary(%v) didn't fail", test.name, test.data)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"time"
+)
+
+// SeriesPoint is how many times a word was used in one chunk or bucket, out of how many tweets.
+type SeriesPoint struct {
+	Start  time.Time `json:"start"`
+	Count  int       `json:"count"`
+	Tweets int64     `json:"tweets"`
+}
+
+// ChunkSeries returns the count of word in every chunk, in the same order. The chunks must be retained
+// (see Chunk.Retain).
+func ChunkSeries(chunks []*Chunk, word string) []SeriesPoint {
+	points := make([]SeriesPoint, len(chunks))
+	// the chunks that used the word hold a reference to its ID, so it can't be given to another word meanwhile.
+	// If the word isn't in the symbol table, none of them used it.
+	id, found := Symbols.Lookup(word)
+	for i, chunk := range chunks {
+		points[i] = SeriesPoint{Start: chunk.Start, Tweets: chunk.Tweets}
+		if found && chunk.Words != nil {
+			points[i].Count = chunk.Words.GetId(id)
+		}
+	}
+
+	return points
+}
+
+// Sparkline sums the counts of points into n (or fewer, if there aren't enough points) consecutive buckets of about
+// the same number of points each.
+func Sparkline(points []SeriesPoint, n int) []int {
+	if n > len(points) {
+		n = len(points)
+	}
+
+	sparkline := make([]int, n)
+	for i := range sparkline {
+		for _, point := range points[i*len(points)/n : (i+1)*len(points)/n] {
+			sparkline[i] += point.Count
+		}
+	}
+
+	return sparkline
+}
@@ -0,0 +1,38 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSparkline(t *testing.T) {
+	points := func(counts ...int) []SeriesPoint {
+		p := make([]SeriesPoint, len(counts))
+		for i, count := range counts {
+			p[i].Count = count
+		}
+		return p
+	}
+
+	tests := []struct {
+		name   string
+		points []SeriesPoint
+		n      int
+		want   []int
+	}{
+		{"no points", nil, 5, []int{}},
+		{"one per point", points(1, 2, 3), 3, []int{1, 2, 3}},
+		{"more buckets than points", points(1, 2, 3), 10, []int{1, 2, 3}},
+		{"even buckets", points(1, 2, 3, 4, 5, 6), 3, []int{3, 7, 11}},
+		{"uneven buckets", points(1, 1, 1, 1, 1), 2, []int{2, 3}},
+		{"one bucket", points(4, 0, 2), 1, []int{6}},
+		{"no buckets", points(1, 2), 0, []int{}},
+	}
+
+	for _, test := range tests {
+		got := Sparkline(test.points, test.n)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Sparkline(%v, %d) = %v, want %v", test.name, test.points, test.n, got, test.want)
+		}
+	}
+}
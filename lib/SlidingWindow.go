@@ -0,0 +1,250 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sync"
+	"time"
+)
+
+// Baseline is a long term usage rate that the focus window is compared against.
+// The clock only moves forward through AddTweet, which lets the caller decide what time means.
+type Baseline interface {
+	Lock()
+	Unlock()
+	AddTweet(at time.Time)
+	IncWord(word string)
+	// RateUnlocked returns how many times word is used per tweet. The caller must hold the lock.
+	RateUnlocked(word string) float64
+}
+
+// WindowBucket aggregates the words of every tweet in [Start, Start + the window's BucketPeriod).
+type WindowBucket struct {
+	Start  time.Time
+	Tweets int64
+	Words  *SealedChunk
+}
+
+// SlidingWindow counts the words of the last Length of tweets, e.g. the last 7 days, out of buckets of BucketPeriod.
+// Once the oldest bucket falls out of the window it is subtracted from Total, the same way the focus window drops
+// its oldest chunk.
+type SlidingWindow struct {
+	BucketPeriod time.Duration
+	Length       time.Duration
+	// sealed WindowBuckets, oldest first
+	buckets *CircularQueue
+	current *IdDiff
+	// the start and tweet count of the current bucket
+	currentStart  time.Time
+	currentTweets int64
+	// sum of every bucket, including the current one
+	Total  *WordDiff
+	Tweets int64
+	mutex  sync.Mutex
+}
+
+func NewSlidingWindow(bucketPeriod time.Duration, length time.Duration) *SlidingWindow {
+	w := &SlidingWindow{}
+	w.BucketPeriod = bucketPeriod
+	w.Length = length
+	// one extra slot because a CircularQueue of n holds n-1 elements, and one for the bucket being evicted
+	w.buckets = NewCircularQueue(int(length/bucketPeriod) + 2)
+	w.current = NewIdDiff()
+	w.Total = NewWordDiff()
+
+	return w
+}
+
+func (w *SlidingWindow) Lock() {
+	w.mutex.Lock()
+}
+
+func (w *SlidingWindow) Unlock() {
+	w.mutex.Unlock()
+}
+
+func (w *SlidingWindow) dropOldestUnlocked() {
+	oldest := w.buckets.Dequeue().(*WindowBucket)
+	oldest.Words.SubFrom(w.Total)
+	oldest.Words.Release()
+	w.Tweets -= oldest.Tweets
+}
+
+func (w *SlidingWindow) evictUnlocked(now time.Time) {
+	for !w.buckets.IsEmpty() {
+		oldest := w.buckets.First().(*WindowBucket)
+		if oldest.Start.Add(w.BucketPeriod).After(now.Add(-w.Length)) {
+			return
+		}
+
+		w.dropOldestUnlocked()
+	}
+}
+
+// pushUnlocked adds a sealed bucket. If the buckets don't fit, e.g. because the clock went backwards,
+// the oldest one is dropped to make room, since a bucket that isn't in the queue could never be subtracted again.
+func (w *SlidingWindow) pushUnlocked(bucket *WindowBucket) {
+	if w.buckets.IsFull() {
+		w.dropOldestUnlocked()
+	}
+	w.buckets.Enqueue(bucket)
+}
+
+// SetLength changes how much time the window covers, e.g. to the configured length after restoring a backup.
+// When it shrinks, the buckets that fall out are dropped right away.
+func (w *SlidingWindow) SetLength(length time.Duration) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.Length = length
+	w.evictUnlocked(w.currentStart)
+	capacity := int(length/w.BucketPeriod) + 2
+	// a CircularQueue of n holds n-1 elements
+	for w.buckets.Len() > capacity-1 {
+		w.dropOldestUnlocked()
+	}
+	resized := NewCircularQueue(capacity)
+	w.buckets.Walk(func(obj interface{}) {
+		resized.Enqueue(obj)
+	})
+	w.buckets = resized
+	w.Total.Prune(0)
+}
+
+// AddTweet counts one tweet at time at, sealing the current bucket if at is past its end.
+func (w *SlidingWindow) AddTweet(at time.Time) {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.currentStart.IsZero() {
+		w.currentStart = at.Truncate(w.BucketPeriod)
+	} else if !at.Before(w.currentStart.Add(w.BucketPeriod)) {
+		w.pushUnlocked(&WindowBucket{Start: w.currentStart, Tweets: w.currentTweets, Words: w.current.Seal()})
+		w.currentStart = at.Truncate(w.BucketPeriod)
+		w.currentTweets = 0
+		// prune the 0 counts left behind by the buckets we subtract
+		w.evictUnlocked(at)
+		w.Total.Prune(0)
+	}
+
+	w.currentTweets++
+	w.Tweets++
+}
+
+func (w *SlidingWindow) IncWord(word string) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.current.IncWord(word)
+	w.Total.IncWord(word)
+}
+
+func (w *SlidingWindow) RateUnlocked(word string) float64 {
+	if w.Tweets == 0 {
+		return 0
+	}
+
+	return float64(w.Total.Get(word)) / float64(w.Tweets)
+}
+
+// Start returns the start of the oldest bucket still in the window.
+func (w *SlidingWindow) Start() time.Time {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.buckets.IsEmpty() {
+		return w.currentStart
+	}
+
+	return w.buckets.First().(*WindowBucket).Start
+}
+
+// Release drops the references the buckets hold in Symbols. The window must not be used afterwards.
+func (w *SlidingWindow) Release() {
+	w.Lock()
+	defer w.Unlock()
+
+	for !w.buckets.IsEmpty() {
+		w.buckets.Dequeue().(*WindowBucket).Words.Release()
+	}
+	w.current.Release()
+}
+
+// WalkIds calls walkFunc for every word ID that a bucket of the window holds, once per bucket.
+func (w *SlidingWindow) WalkIds(walkFunc func(uint32)) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.buckets.Walk(func(obj interface{}) {
+		obj.(*WindowBucket).Words.Walk(func(id uint32, count int) {
+			walkFunc(id)
+		})
+	})
+	w.current.Walk(func(id uint32, count int) {
+		walkFunc(id)
+	})
+}
+
+type slidingWindowPublic struct {
+	BucketPeriod  time.Duration
+	Length        time.Duration
+	Buckets       []*WindowBucket
+	Current       *SealedChunk
+	CurrentStart  time.Time
+	CurrentTweets int64
+	Total         *WordDiff
+	Tweets        int64
+}
+
+// GobEncode lets the window be part of the backups. The caller must hold the lock.
+func (w *SlidingWindow) GobEncode() ([]byte, error) {
+	p := &slidingWindowPublic{
+		BucketPeriod:  w.BucketPeriod,
+		Length:        w.Length,
+		Current:       newSealedChunk(w.current.Words),
+		CurrentStart:  w.currentStart,
+		CurrentTweets: w.currentTweets,
+		Total:         w.Total,
+		Tweets:        w.Tweets,
+	}
+	w.buckets.Walk(func(obj interface{}) {
+		p.Buckets = append(p.Buckets, obj.(*WindowBucket))
+	})
+
+	buffer := bytes.NewBuffer([]byte{})
+	err := gob.NewEncoder(buffer).Encode(p)
+
+	return buffer.Bytes(), err
+}
+
+func (w *SlidingWindow) GobDecode(data []byte) error {
+	p := &slidingWindowPublic{}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(p)
+	if err != nil {
+		return err
+	}
+
+	w.BucketPeriod = p.BucketPeriod
+	w.Length = p.Length
+	w.buckets = NewCircularQueue(int(p.Length/p.BucketPeriod) + 2)
+	for _, bucket := range p.Buckets {
+		w.buckets.Enqueue(bucket)
+	}
+	// the current bucket has to stay writable, so thaw it back into an IdDiff.
+	// its IDs already hold references in the restored symbol table.
+	w.current = NewIdDiff()
+	if p.Current != nil {
+		p.Current.Walk(func(id uint32, count int) {
+			w.current.Words[id] = count
+		})
+	}
+	w.currentStart = p.CurrentStart
+	w.currentTweets = p.CurrentTweets
+	w.Total = p.Total
+	if w.Total == nil {
+		w.Total = NewWordDiff()
+	}
+	w.Tweets = p.Tweets
+
+	return nil
+}
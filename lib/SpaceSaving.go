@@ -0,0 +1,126 @@
+package lib
+
+import "container/heap"
+
+type SpaceSavingEntry struct {
+	Word  string
+	Count int
+	// how much Count may be overestimated by. The true count is in [Count - Error, Count]
+	Error int
+}
+
+// SpaceSaving keeps the K most frequent words of a stream (Metwally et al.) in fixed memory.
+// Any word that occurs more than Total / K times is guaranteed to be tracked, and no count is overestimated
+// by more than Total / K. Entries are kept in a min-heap on Count so replacing the smallest is O(log K).
+type SpaceSaving struct {
+	K       int
+	Total   int64
+	Entries []SpaceSavingEntry
+	// word -> position in Entries, rebuilt on demand after a restore
+	index map[string]int
+}
+
+func NewSpaceSaving(k int) *SpaceSaving {
+	s := &SpaceSaving{}
+	s.K = k
+	s.Entries = make([]SpaceSavingEntry, 0, k)
+
+	return s
+}
+
+func (s *SpaceSaving) Len() int {
+	return len(s.Entries)
+}
+
+func (s *SpaceSaving) Less(i, j int) bool {
+	return s.Entries[i].Count < s.Entries[j].Count
+}
+
+func (s *SpaceSaving) Swap(i, j int) {
+	s.Entries[i], s.Entries[j] = s.Entries[j], s.Entries[i]
+	s.index[s.Entries[i].Word] = i
+	s.index[s.Entries[j].Word] = j
+}
+
+func (s *SpaceSaving) Push(x interface{}) {
+	entry := x.(SpaceSavingEntry)
+	s.index[entry.Word] = len(s.Entries)
+	s.Entries = append(s.Entries, entry)
+}
+
+func (s *SpaceSaving) Pop() interface{} {
+	entry := s.Entries[len(s.Entries)-1]
+	s.Entries = s.Entries[:len(s.Entries)-1]
+	delete(s.index, entry.Word)
+
+	return entry
+}
+
+func (s *SpaceSaving) reindex() {
+	s.index = make(map[string]int, len(s.Entries))
+	for i, entry := range s.Entries {
+		s.index[entry.Word] = i
+	}
+}
+
+// Add increments word by count, evicting the least frequent word if there is no room for it.
+func (s *SpaceSaving) Add(word string, count int) {
+	if s.index == nil {
+		s.reindex()
+	}
+	s.Total += int64(count)
+
+	if i, ok := s.index[word]; ok {
+		s.Entries[i].Count += count
+		heap.Fix(s, i)
+		return
+	}
+
+	// copy the word so we don't pin the text it was sliced from
+	word = string([]byte(word))
+	if len(s.Entries) < s.K {
+		heap.Push(s, SpaceSavingEntry{Word: word, Count: count})
+		return
+	}
+
+	// take over the smallest entry, inheriting its count as our error
+	smallest := s.Entries[0]
+	delete(s.index, smallest.Word)
+	s.Entries[0] = SpaceSavingEntry{Word: word, Count: smallest.Count + count, Error: smallest.Count}
+	s.index[word] = 0
+	heap.Fix(s, 0)
+}
+
+// Get returns the tracked count of word, or 0 if it is not one of the top K.
+func (s *SpaceSaving) Get(word string) int {
+	if s.index == nil {
+		s.reindex()
+	}
+
+	i, ok := s.index[word]
+	if !ok {
+		return 0
+	}
+
+	return s.Entries[i].Count
+}
+
+// Walk calls walkFunc for every tracked entry, in no particular order.
+func (s *SpaceSaving) Walk(walkFunc func(SpaceSavingEntry)) {
+	for _, entry := range s.Entries {
+		walkFunc(entry)
+	}
+}
+
+// Filter drops every entry that keep returns false for, freeing up its slot.
+func (s *SpaceSaving) Filter(keep func(SpaceSavingEntry) bool) {
+	entries := s.Entries[:0]
+	for _, entry := range s.Entries {
+		if keep(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	s.Entries = entries
+	s.reindex()
+	heap.Init(s)
+}
@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log"
+	"sync"
+)
+
+// SymbolTable interns words so that chunks can store a uint32 per word instead of their own copy of every string.
+// Each ID is reference counted: whoever holds an ID (usually a chunk in the wordDiffQueue) retains it, and once
+// the last holder releases it the slot is freed and reused by the next new word.
+type SymbolTable struct {
+	Words []string
+	Refs  []int32
+	Free  []uint32
+	// rebuilt from Words on demand, so we don't store every string twice in the backups
+	ids   map[string]uint32
+	mutex sync.Mutex
+}
+
+// Symbols is the process-wide symbol table. Everything that stores word IDs resolves them through it.
+var Symbols *SymbolTable = NewSymbolTable()
+
+func NewSymbolTable() *SymbolTable {
+	// ids is built lazily, which also covers tables that were just decoded from a backup
+	return &SymbolTable{}
+}
+
+func (t *SymbolTable) Lock() {
+	t.mutex.Lock()
+}
+
+func (t *SymbolTable) Unlock() {
+	t.mutex.Unlock()
+}
+
+func (t *SymbolTable) reindexUnlocked() {
+	t.ids = make(map[string]uint32, len(t.Words))
+	for id, word := range t.Words {
+		if t.Refs[id] > 0 {
+			t.ids[word] = uint32(id)
+		}
+	}
+}
+
+// Intern returns the ID for word and takes a reference on it. The caller must Release it once done.
+func (t *SymbolTable) Intern(word string) uint32 {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.ids == nil {
+		t.reindexUnlocked()
+	}
+
+	id, ok := t.ids[word]
+	if ok {
+		t.Refs[id]++
+		return id
+	}
+
+	// the tokens we get are substrings of the whole tweet, so copy the word to avoid pinning the tweet text
+	word = string([]byte(word))
+	if len(t.Free) > 0 {
+		id = t.Free[len(t.Free)-1]
+		t.Free = t.Free[:len(t.Free)-1]
+		t.Words[id] = word
+		t.Refs[id] = 1
+	} else {
+		id = uint32(len(t.Words))
+		t.Words = append(t.Words, word)
+		t.Refs = append(t.Refs, 1)
+	}
+	t.ids[word] = id
+
+	return id
+}
+
+// Lookup returns the ID of word without taking a reference. ok is false if the word is not interned.
+func (t *SymbolTable) Lookup(word string) (uint32, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.ids == nil {
+		t.reindexUnlocked()
+	}
+	id, ok := t.ids[word]
+
+	return id, ok
+}
+
+func (t *SymbolTable) WordUnlocked(id uint32) string {
+	if int(id) >= len(t.Words) {
+		return ""
+	}
+
+	return t.Words[id]
+}
+
+func (t *SymbolTable) Word(id uint32) string {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.WordUnlocked(id)
+}
+
+func (t *SymbolTable) Retain(id uint32) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.Refs[id]++
+}
+
+func (t *SymbolTable) releaseUnlocked(id uint32) {
+	t.Refs[id]--
+	if t.Refs[id] > 0 {
+		return
+	}
+
+	if t.ids != nil {
+		delete(t.ids, t.Words[id])
+	}
+	t.Words[id] = ""
+	t.Refs[id] = 0
+	t.Free = append(t.Free, id)
+}
+
+// Release drops a reference to id. Once nothing references it, the word is pruned from the table.
+func (t *SymbolTable) Release(id uint32) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.releaseUnlocked(id)
+}
+
+// Len returns the number of live words in the table.
+func (t *SymbolTable) Len() int {
+	t.Lock()
+	defer t.Unlock()
+
+	return len(t.Words) - len(t.Free)
+}
+
+// RebuildRefs counts every reference again: walk has to call retain once for each ID of every holder. Words that
+// nothing holds are freed. This is for backups, whose counts include holders that were not saved, like the open chunk.
+// Nothing else may use the table until it returns.
+func (t *SymbolTable) RebuildRefs(walk func(retain func(id uint32))) {
+	t.Lock()
+	refs := make([]int32, len(t.Words))
+	t.Unlock()
+
+	// the holders may resolve IDs while they are walked, so don't hold the lock
+	walk(func(id uint32) {
+		refs[id]++
+	})
+
+	t.Lock()
+	defer t.Unlock()
+	t.Refs = refs
+	t.Free = t.Free[:0]
+	for id := len(t.Words) - 1; id >= 0; id-- {
+		if refs[id] == 0 {
+			t.Words[id] = ""
+			t.Free = append(t.Free, uint32(id))
+		}
+	}
+	t.reindexUnlocked()
+}
+
+// Snapshot returns a copy of the table that can be encoded while the original keeps changing.
+func (t *SymbolTable) Snapshot() *SymbolTable {
+	t.Lock()
+	defer t.Unlock()
+
+	return &SymbolTable{
+		Words: append([]string(nil), t.Words...),
+		Refs:  append([]int32(nil), t.Refs...),
+		Free:  append([]uint32(nil), t.Free...),
+	}
+}
+
+func (t *SymbolTable) Serialize() []byte {
+	t.Lock()
+	defer t.Unlock()
+	buffer := bytes.NewBuffer([]byte{})
+	encoder := gob.NewEncoder(buffer)
+	err := encoder.Encode(t)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
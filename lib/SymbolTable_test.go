@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSymbolTableRebuildRefs(t *testing.T) {
+	table := NewSymbolTable()
+	a := table.Intern("alpha")
+	b := table.Intern("beta")
+	c := table.Intern("gamma")
+	// references from holders that are gone, e.g. the open chunk of a backup
+	table.Intern("alpha")
+	table.Intern("beta")
+
+	table.RebuildRefs(func(retain func(uint32)) {
+		retain(a)
+		retain(c)
+		retain(c)
+	})
+
+	if want := []int32{1, 0, 2}; !reflect.DeepEqual(table.Refs, want) {
+		t.Errorf("Refs = %v, want %v", table.Refs, want)
+	}
+	if _, ok := table.Lookup("beta"); ok {
+		t.Errorf("beta is still interned after nothing held it")
+	}
+	if got := table.Word(b); got != "" {
+		t.Errorf("Word(%d) = %q, want it freed", b, got)
+	}
+	if got := table.Len(); got != 2 {
+		t.Errorf("Len() = %d, want 2", got)
+	}
+
+	// the freed slot is reused
+	if id := table.Intern("delta"); id != b {
+		t.Errorf("Intern(delta) = %d, want the freed %d", id, b)
+	}
+	table.Release(a)
+	if _, ok := table.Lookup("alpha"); ok {
+		t.Errorf("alpha is still interned after its last reference was released")
+	}
+}
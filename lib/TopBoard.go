@@ -0,0 +1,163 @@
+package lib
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// BoardSettings tune how sticky a TopBoard is.
+type BoardSettings struct {
+	Size int
+	// a word stays on the board at least this long once it is on it
+	MinDwell time.Duration
+	// weight of the latest score in the smoothed score
+	Alpha float32
+	// a word gets on the board when there is room and its smoothed score would make the raw list, and otherwise has to
+	// beat the weakest word on the board by EnterRatio times to replace it
+	EnterRatio float32
+	// a word leaves the board once its smoothed score falls below ExitRatio times the cut-off
+	ExitRatio float32
+}
+
+// BoardInput is a word on the raw top list. Data is handed back with the word's BoardEntry.
+type BoardInput struct {
+	Word  string
+	Score float32
+	Data  interface{}
+}
+
+// BoardEntry is a word on the board.
+type BoardEntry struct {
+	Word string
+	// the smoothed score
+	Score float32
+	// when it got on the board
+	Since time.Time
+	// from the last time the word was on the raw list
+	Data interface{}
+}
+
+type boardWord struct {
+	entry    BoardEntry
+	onBoard  bool
+	ranked   bool
+	smoothed float32
+}
+
+// TopBoard is a steadier version of the top list: it smooths the scores of the raw list with an exponential moving
+// average, and a word has to clear a higher bar to get on the board than to stay on it, so words near the cut-off
+// don't pop in and out every time the list is recomputed.
+type TopBoard struct {
+	words map[string]*boardWord
+	mutex sync.Mutex
+}
+
+func NewTopBoard() *TopBoard {
+	b := &TopBoard{}
+	b.words = make(map[string]*boardWord)
+
+	return b
+}
+
+// Update takes the next raw top list, best first. It should be about twice as long as the board, so the words just
+// below the cut-off are tracked too.
+func (b *TopBoard) Update(now time.Time, raw []BoardInput, s BoardSettings) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// the score a word needs to make the raw list
+	var cutoff float32
+	if len(raw) >= s.Size && s.Size > 0 {
+		cutoff = raw[s.Size-1].Score
+	}
+
+	for _, word := range b.words {
+		word.ranked = false
+	}
+	for _, input := range raw {
+		word, found := b.words[input.Word]
+		if !found {
+			word = &boardWord{smoothed: input.Score}
+			word.entry.Word = input.Word
+			b.words[input.Word] = word
+		} else {
+			word.smoothed = s.Alpha*input.Score + (1-s.Alpha)*word.smoothed
+		}
+		word.ranked = true
+		word.entry.Data = input.Data
+	}
+
+	var members []*boardWord
+	var candidates []*boardWord
+	for name, word := range b.words {
+		if !word.ranked {
+			word.smoothed = (1 - s.Alpha) * word.smoothed
+		}
+		settled := now.Sub(word.entry.Since) >= s.MinDwell
+		if word.onBoard && settled && (!word.ranked || word.smoothed < s.ExitRatio*cutoff) {
+			word.onBoard = false
+			continue
+		}
+		switch {
+		case !word.onBoard && !word.ranked:
+			delete(b.words, name)
+		case word.onBoard:
+			members = append(members, word)
+		case word.smoothed >= cutoff:
+			candidates = append(candidates, word)
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool { return members[i].smoothed < members[j].smoothed })
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].smoothed > candidates[j].smoothed })
+	for _, candidate := range candidates {
+		if len(members) < s.Size {
+			candidate.onBoard = true
+			candidate.entry.Since = now
+			members = append(members, candidate)
+			continue
+		}
+
+		// replace the weakest word that has been on the board long enough
+		replaced := false
+		for i, member := range members {
+			if candidate.smoothed < s.EnterRatio*member.smoothed {
+				break
+			}
+			if now.Sub(member.entry.Since) >= s.MinDwell {
+				member.onBoard = false
+				candidate.onBoard = true
+				candidate.entry.Since = now
+				members[i] = candidate
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			break
+		}
+		sort.Slice(members, func(i, j int) bool { return members[i].smoothed < members[j].smoothed })
+	}
+}
+
+// Top returns the best n words on the board, best first.
+func (b *TopBoard) Top(n int) []BoardEntry {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	entries := make([]BoardEntry, 0, n)
+	for _, word := range b.words {
+		if word.onBoard {
+			entry := word.entry
+			entry.Score = word.smoothed
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Score > entries[j].Score })
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+
+	return entries
+}
@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTopBoardUpdate(t *testing.T) {
+	type update struct {
+		// since the first update
+		at time.Duration
+		// best first
+		words  []string
+		scores []float32
+	}
+	settings := BoardSettings{Size: 2, MinDwell: 0, Alpha: 1, EnterRatio: 1.1, ExitRatio: 0.8}
+	dwell := settings
+	dwell.MinDwell = time.Minute
+	smooth := settings
+	smooth.Alpha = 0.5
+
+	tests := []struct {
+		name     string
+		settings BoardSettings
+		updates  []update
+		want     []string
+	}{
+		{
+			name:     "fills up from the raw list",
+			settings: settings,
+			updates:  []update{{0, []string{"a", "b", "c", "d"}, []float32{10, 9, 8, 7}}},
+			want:     []string{"a", "b"},
+		},
+		{
+			name:     "a word has to beat the weakest by the enter ratio",
+			settings: settings,
+			updates: []update{
+				{0, []string{"a", "b", "c"}, []float32{10, 9, 8}},
+				{time.Second, []string{"a", "c", "b"}, []float32{10, 9.5, 9}},
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name:     "a much better word replaces the weakest",
+			settings: settings,
+			updates: []update{
+				{0, []string{"a", "b", "c"}, []float32{10, 9, 8}},
+				{time.Second, []string{"c", "a", "b"}, []float32{12, 10, 9}},
+			},
+			want: []string{"c", "a"},
+		},
+		{
+			name:     "words stay on for the minimum dwell",
+			settings: dwell,
+			updates: []update{
+				{0, []string{"a", "b", "c"}, []float32{10, 9, 8}},
+				{10 * time.Second, []string{"c", "a", "b"}, []float32{12, 10, 9}},
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name:     "and are replaced after it",
+			settings: dwell,
+			updates: []update{
+				{0, []string{"a", "b", "c"}, []float32{10, 9, 8}},
+				{2 * time.Minute, []string{"c", "a", "b"}, []float32{12, 10, 9}},
+			},
+			want: []string{"c", "a"},
+		},
+		{
+			name:     "a word that drops off the raw list leaves",
+			settings: settings,
+			updates: []update{
+				{0, []string{"a", "b", "c"}, []float32{10, 9, 8}},
+				{time.Second, []string{"a", "d"}, []float32{10, 5}},
+			},
+			want: []string{"a", "d"},
+		},
+		{
+			name:     "smoothing keeps one bad update from pushing a word out",
+			settings: smooth,
+			updates: []update{
+				{0, []string{"a", "b", "c"}, []float32{10, 9, 3}},
+				{time.Second, []string{"a", "c", "b"}, []float32{10, 9, 6}},
+			},
+			// b is smoothed to 7.5, c to 6
+			want: []string{"a", "b"},
+		},
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		board := NewTopBoard()
+		for _, u := range test.updates {
+			raw := make([]BoardInput, len(u.words))
+			for i, word := range u.words {
+				raw[i] = BoardInput{Word: word, Score: u.scores[i]}
+			}
+			board.Update(start.Add(u.at), raw, test.settings)
+		}
+
+		var got []string
+		for _, entry := range board.Top(test.settings.Size) {
+			got = append(got, entry.Word)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// TopIndexEntry is a scored word in a TopIndex. Its Input doesn't keep the chunks, which would hold on to them
+// long after they have left the window.
+type TopIndexEntry struct {
+	Input ScoreInput
+	Score WordScore
+}
+
+type topIndexItem struct {
+	word    string
+	score   float32
+	version uint64
+}
+
+// a max heap by score
+type topIndexHeap []topIndexItem
+
+func (h topIndexHeap) Len() int            { return len(h) }
+func (h topIndexHeap) Less(i, j int) bool  { return h[i].score > h[j].score }
+func (h topIndexHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *topIndexHeap) Push(x interface{}) { *h = append(*h, x.(topIndexItem)) }
+func (h *topIndexHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+type topIndexScore struct {
+	entry   TopIndexEntry
+	version uint64
+}
+
+// TopIndex keeps the best scoring words without walking every word on every query.
+// Whenever a chunk enters or leaves the window its words are marked as touched, and only those (plus the current
+// top words, whose scores move with the window's tweet count) are rescored on the next pass.
+// Rescoring a word pushes a new heap item instead of fixing up the old one, which is then skipped as stale when it
+// comes up. After every pass the best Capacity words are copied out, so Top never waits on a pass.
+type TopIndex struct {
+	Capacity int
+	scores   map[string]topIndexScore
+	heap     topIndexHeap
+	version  uint64
+	touched  map[string]bool
+	// the best Capacity entries after the last pass, best first
+	top          []TopIndexEntry
+	mutex        sync.Mutex
+	touchedMutex sync.Mutex
+	topMutex     sync.RWMutex
+}
+
+func NewTopIndex(capacity int) *TopIndex {
+	t := &TopIndex{}
+	t.Capacity = capacity
+	t.scores = make(map[string]topIndexScore)
+	t.touched = make(map[string]bool)
+
+	return t
+}
+
+// Touch marks a word to be rescored on the next pass.
+func (t *TopIndex) Touch(word string) {
+	t.touchedMutex.Lock()
+	defer t.touchedMutex.Unlock()
+
+	t.touched[word] = true
+}
+
+// TouchChunk marks every word of a chunk to be rescored on the next pass.
+func (t *TopIndex) TouchChunk(chunk *SealedChunk) {
+	if chunk == nil {
+		return
+	}
+
+	t.touchedMutex.Lock()
+	defer t.touchedMutex.Unlock()
+
+	chunk.WalkWords(func(word string, count int) {
+		t.touched[word] = true
+	})
+}
+
+// TakeTouched returns the words that have to be rescored in this pass: the touched words and the current top words.
+func (t *TopIndex) TakeTouched() []string {
+	t.touchedMutex.Lock()
+	touched := t.touched
+	t.touched = make(map[string]bool)
+	t.touchedMutex.Unlock()
+
+	t.topMutex.RLock()
+	for _, entry := range t.top {
+		touched[entry.Input.Word] = true
+	}
+	t.topMutex.RUnlock()
+
+	words := make([]string, 0, len(touched))
+	for word := range touched {
+		words = append(words, word)
+	}
+
+	return words
+}
+
+// Set records the new score of a word, or removes it if entry is nil.
+func (t *TopIndex) Set(word string, entry *TopIndexEntry) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if entry == nil {
+		// its heap items are stale now
+		delete(t.scores, word)
+		return
+	}
+
+	t.version++
+	t.scores[word] = topIndexScore{entry: *entry, version: t.version}
+	heap.Push(&t.heap, topIndexItem{word: word, score: entry.Score.Score, version: t.version})
+}
+
+// Reset forgets every score, for rebuilding the index from scratch.
+func (t *TopIndex) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.scores = make(map[string]topIndexScore)
+	t.heap = nil
+}
+
+// Refresh finishes a pass: it copies out the best Capacity entries, and compacts the heap once it is mostly
+// stale items.
+func (t *TopIndex) Refresh() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.heap) > 2*len(t.scores)+t.Capacity {
+		t.heap = make(topIndexHeap, 0, len(t.scores))
+		for word, score := range t.scores {
+			t.heap = append(t.heap, topIndexItem{word: word, score: score.entry.Score.Score, version: score.version})
+		}
+		heap.Init(&t.heap)
+	}
+
+	top := make([]TopIndexEntry, 0, t.Capacity)
+	items := make([]topIndexItem, 0, t.Capacity)
+	for len(top) < t.Capacity && len(t.heap) > 0 {
+		item := heap.Pop(&t.heap).(topIndexItem)
+		score, ok := t.scores[item.word]
+		if !ok || score.version != item.version {
+			continue
+		}
+		top = append(top, score.entry)
+		items = append(items, item)
+	}
+	// they are still the current scores
+	for _, item := range items {
+		heap.Push(&t.heap, item)
+	}
+
+	t.topMutex.Lock()
+	t.top = top
+	t.topMutex.Unlock()
+}
+
+// Top returns up to n of the best entries as of the last pass, best first.
+func (t *TopIndex) Top(n int) []TopIndexEntry {
+	t.topMutex.RLock()
+	defer t.topMutex.RUnlock()
+
+	if n > len(t.top) {
+		n = len(t.top)
+	} else if n < 0 {
+		n = 0
+	}
+	top := make([]TopIndexEntry, n)
+	copy(top, t.top[:n])
+
+	return top
+}
@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Trend is one stretch of time a word spent on the top list.
+type Trend struct {
+	Word string `json:"word"`
+	// when it entered the top list, and when it was last on it
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// how long it was on the top list, in seconds
+	Duration float64 `json:"duration"`
+	// its best score and when it had it, and its best rank (1 is the top)
+	PeakScore float32   `json:"peakScore"`
+	PeakAt    time.Time `json:"peakAt"`
+	PeakRank  int       `json:"peakRank"`
+	// the word is still on the top list, or left it less than ExitAfter ago
+	Ongoing bool `json:"ongoing"`
+}
+
+// TrendWord is a word on one top list, as given to Observe.
+type TrendWord struct {
+	Word  string
+	Score float32
+	// 1 is the top
+	Rank int
+}
+
+// TrendTracker follows the words on the top list over time, and records when each one entered it, peaked and left.
+// A word only leaves once it has been off the list for ExitAfter, so a word that drops off for a pass or two doesn't
+// turn into several trends.
+type TrendTracker struct {
+	ExitAfter time.Duration
+	Active    map[string]*Trend
+	// the most recent trends that ended, in the order they ended
+	Ended     []Trend
+	MaxTrends int
+	mutex     sync.Mutex
+}
+
+func NewTrendTracker(exitAfter time.Duration, maxTrends int) *TrendTracker {
+	t := &TrendTracker{}
+	t.ExitAfter = exitAfter
+	t.MaxTrends = maxTrends
+	t.Active = make(map[string]*Trend)
+
+	return t
+}
+
+func (t *TrendTracker) Lock() {
+	t.mutex.Lock()
+}
+
+func (t *TrendTracker) Unlock() {
+	t.mutex.Unlock()
+}
+
+// Observe records the top list at now.
+func (t *TrendTracker) Observe(now time.Time, words []TrendWord) {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, word := range words {
+		trend, found := t.Active[word.Word]
+		if !found {
+			trend = &Trend{Word: word.Word, Start: now, PeakScore: word.Score, PeakAt: now, PeakRank: word.Rank, Ongoing: true}
+			t.Active[word.Word] = trend
+		}
+		trend.End = now
+		trend.Duration = now.Sub(trend.Start).Seconds()
+		if word.Score > trend.PeakScore {
+			trend.PeakScore = word.Score
+			trend.PeakAt = now
+		}
+		if word.Rank < trend.PeakRank {
+			trend.PeakRank = word.Rank
+		}
+	}
+
+	var ended []Trend
+	for word, trend := range t.Active {
+		if now.Sub(trend.End) < t.ExitAfter {
+			continue
+		}
+		trend.Ongoing = false
+		ended = append(ended, *trend)
+		delete(t.Active, word)
+	}
+	sort.Slice(ended, func(i, j int) bool { return ended[i].End.Before(ended[j].End) })
+	t.Ended = append(t.Ended, ended...)
+	if len(t.Ended) > t.MaxTrends {
+		t.Ended = t.Ended[len(t.Ended)-t.MaxTrends:]
+	}
+}
+
+// History returns the trends that were on the top list at some point between since and until, optionally only
+// those of one word, ordered by when they started.
+func (t *TrendTracker) History(since time.Time, until time.Time, word string) []Trend {
+	t.Lock()
+	defer t.Unlock()
+
+	trends := make([]Trend, 0)
+	matches := func(trend *Trend) bool {
+		return !trend.End.Before(since) && !trend.Start.After(until) && (word == "" || trend.Word == word)
+	}
+	for i := range t.Ended {
+		if matches(&t.Ended[i]) {
+			trends = append(trends, t.Ended[i])
+		}
+	}
+	for _, trend := range t.Active {
+		if matches(trend) {
+			trends = append(trends, *trend)
+		}
+	}
+	sort.Slice(trends, func(i, j int) bool { return trends[i].Start.Before(trends[j].Start) })
+
+	return trends
+}
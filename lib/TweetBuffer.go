@@ -0,0 +1,286 @@
+package lib
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// BufferedTweet is a tweet kept for search.
+type BufferedTweet struct {
+	ID        string    `json:"id"`
+	AuthorID  string    `json:"authorId"`
+	CreatedAt time.Time `json:"createdAt"`
+	Text      string    `json:"text"`
+	// the chunk it was counted in
+	chunk  uint64
+	tokens []string
+}
+
+// SearchTokens splits text into the lowercase tokens the search matches on.
+// Unlike the words that are counted, short words are kept so phrases like "in tokyo" can match.
+func SearchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '#' && r != '@' && r != '_'
+	})
+}
+
+// indexTokens returns the distinct tokens a tweet is indexed under: its tokens, and its hashtags and mentions without
+// the # or @ too, so searching for worldcup also finds #worldcup. Searching for #worldcup only finds the hashtag.
+func indexTokens(tokens []string) []string {
+	seen := make(map[string]bool, len(tokens))
+	keys := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		for _, key := range []string{token, bareToken(token)} {
+			if key != "" && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys
+}
+
+// bareToken is token without a leading # or @
+func bareToken(token string) string {
+	return strings.TrimLeft(token, "#@")
+}
+
+// TweetBuffer keeps the tweets of the last few chunks, at most MaxTweets of them, with an inverted index from every
+// token to the tweets that use it.
+// Tweets are numbered in the order they come in, so every postings list is sorted, and since the oldest tweets are
+// always evicted first, evicting a tweet only ever drops the front of its postings lists.
+type TweetBuffer struct {
+	MaxTweets int
+	tweets    []BufferedTweet
+	// the number of tweets[0]
+	first    uint64
+	postings map[string][]uint64
+	mutex    sync.Mutex
+}
+
+func NewTweetBuffer(maxTweets int) *TweetBuffer {
+	b := &TweetBuffer{}
+	b.MaxTweets = maxTweets
+	b.postings = make(map[string][]uint64)
+
+	return b
+}
+
+// Add indexes a tweet counted in chunk.
+func (b *TweetBuffer) Add(id string, authorID string, createdAt time.Time, text string, chunk uint64) {
+	tokens := SearchTokens(text)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.tweets) >= b.MaxTweets {
+		b.evictUnlocked(1)
+	}
+
+	n := b.first + uint64(len(b.tweets))
+	for _, key := range indexTokens(tokens) {
+		b.postings[key] = append(b.postings[key], n)
+	}
+	b.tweets = append(b.tweets, BufferedTweet{
+		ID:        id,
+		AuthorID:  authorID,
+		CreatedAt: createdAt,
+		Text:      text,
+		chunk:     chunk,
+		tokens:    tokens,
+	})
+}
+
+func (b *TweetBuffer) evictUnlocked(count int) {
+	for _, tweet := range b.tweets[:count] {
+		for _, key := range indexTokens(tweet.tokens) {
+			if len(b.postings[key]) <= 1 {
+				delete(b.postings, key)
+			} else {
+				b.postings[key] = b.postings[key][1:]
+			}
+		}
+	}
+	b.tweets = b.tweets[count:]
+	b.first += uint64(count)
+}
+
+// Expire evicts the tweets of every chunk up to and including chunk.
+func (b *TweetBuffer) Expire(chunk uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	count := sort.Search(len(b.tweets), func(i int) bool { return b.tweets[i].chunk > chunk })
+	b.evictUnlocked(count)
+}
+
+// Len returns the number of tweets in the buffer, and when the oldest one was created.
+func (b *TweetBuffer) Len() (int, time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.tweets) == 0 {
+		return 0, time.Time{}
+	}
+
+	return len(b.tweets), b.tweets[0].CreatedAt
+}
+
+// SearchQuery is a parsed query: any of Clauses has to match, and a clause matches when all of its phrases do.
+// A phrase of one token is just a word.
+type SearchQuery struct {
+	Clauses [][][]string
+}
+
+// ParseSearchQuery parses words, "quoted phrases" and OR, e.g. `"world cup" final OR worldcup`.
+// Terms next to each other are ANDed, and AND binds tighter than OR. A word without # or @ also matches the hashtag
+// or mention, see indexTokens.
+func ParseSearchQuery(q string) (*SearchQuery, error) {
+	query := &SearchQuery{}
+	var clause [][]string
+
+	endClause := func() {
+		if len(clause) > 0 {
+			query.Clauses = append(query.Clauses, clause)
+		}
+		clause = nil
+	}
+
+	rest := q
+	for {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+		if rest == "" {
+			break
+		}
+
+		var term string
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end == -1 {
+				return nil, errors.New("unterminated quote")
+			}
+			term, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end == -1 {
+				end = len(rest)
+			}
+			term, rest = rest[:end], rest[end:]
+			if term == "OR" {
+				endClause()
+				continue
+			}
+		}
+
+		phrase := SearchTokens(term)
+		if len(phrase) > 0 {
+			clause = append(clause, phrase)
+		}
+	}
+	endClause()
+
+	if len(query.Clauses) == 0 {
+		return nil, errors.New("no terms to search for")
+	}
+
+	return query, nil
+}
+
+func containsPhrase(tokens []string, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(tokens); i++ {
+		match := true
+		for j, token := range phrase {
+			if tokens[i+j] != token && bareToken(tokens[i+j]) != token {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasPosting(postings []uint64, n uint64) bool {
+	i := sort.Search(len(postings), func(i int) bool { return postings[i] >= n })
+	return i < len(postings) && postings[i] == n
+}
+
+// searchClauseUnlocked returns up to limit tweets matching clause, newest first.
+func (b *TweetBuffer) searchClauseUnlocked(clause [][]string, limit int) []uint64 {
+	// walk the shortest postings list and look the tweets up in the others
+	var lists [][]uint64
+	for _, phrase := range clause {
+		for _, token := range phrase {
+			postings, found := b.postings[token]
+			if !found {
+				return nil
+			}
+			lists = append(lists, postings)
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+
+	var matches []uint64
+	for i := len(lists[0]) - 1; i >= 0 && len(matches) < limit; i-- {
+		n := lists[0][i]
+		match := true
+		for _, postings := range lists[1:] {
+			if !hasPosting(postings, n) {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+
+		tokens := b.tweets[n-b.first].tokens
+		for _, phrase := range clause {
+			if len(phrase) > 1 && !containsPhrase(tokens, phrase) {
+				match = false
+				break
+			}
+		}
+		if match {
+			matches = append(matches, n)
+		}
+	}
+
+	return matches
+}
+
+// Search returns up to limit of the newest tweets that match query.
+func (b *TweetBuffer) Search(query *SearchQuery, limit int) []BufferedTweet {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	found := make(map[uint64]bool)
+	var matches []uint64
+	for _, clause := range query.Clauses {
+		for _, n := range b.searchClauseUnlocked(clause, limit) {
+			if !found[n] {
+				found[n] = true
+				matches = append(matches, n)
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i] > matches[j] })
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	tweets := make([]BufferedTweet, len(matches))
+	for i, n := range matches {
+		tweets[i] = b.tweets[n-b.first]
+	}
+
+	return tweets
+}
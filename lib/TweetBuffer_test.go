@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want [][][]string
+		err  bool
+	}{
+		{q: "worldcup", want: [][][]string{{{"worldcup"}}}},
+		{q: "World Cup", want: [][][]string{{{"world"}, {"cup"}}}},
+		{q: `"world cup" final OR worldcup`, want: [][][]string{{{"world", "cup"}, {"final"}}, {{"worldcup"}}}},
+		{q: "#WorldCup @fifa", want: [][][]string{{{"#worldcup"}, {"@fifa"}}}},
+		// a term with punctuation in it is a phrase
+		{q: "hello,world", want: [][][]string{{{"hello", "world"}}}},
+		{q: "a OR OR b", want: [][][]string{{{"a"}}, {{"b"}}}},
+		// only an upper case OR is special
+		{q: "a or b", want: [][][]string{{{"a"}, {"or"}, {"b"}}}},
+		{q: `"" x`, want: [][][]string{{{"x"}}}},
+		{q: `"world cup`, err: true},
+		{q: "", err: true},
+		{q: "OR", err: true},
+		{q: "!!!", err: true},
+	}
+
+	for _, test := range tests {
+		query, err := ParseSearchQuery(test.q)
+		if test.err {
+			if err == nil {
+				t.Errorf("ParseSearchQuery(%q) = %q, want an error", test.q, query.Clauses)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSearchQuery(%q) failed: %v", test.q, err)
+			continue
+		}
+		if !reflect.DeepEqual(query.Clauses, test.want) {
+			t.Errorf("ParseSearchQuery(%q) = %q, want %q", test.q, query.Clauses, test.want)
+		}
+	}
+}
+
+func TestSearchHashtags(t *testing.T) {
+	b := NewTweetBuffer(10)
+	b.Add("1", "a", time.Time{}, "Watching the #WorldCup final", 1)
+	b.Add("2", "b", time.Time{}, "worldcup tickets", 1)
+	b.Add("3", "c", time.Time{}, "thanks @fifa", 1)
+
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"worldcup", []string{"2", "1"}},
+		{"#worldcup", []string{"1"}},
+		{`"worldcup final"`, []string{"1"}},
+		{"fifa", []string{"3"}},
+		{"@fifa", []string{"3"}},
+		{"#fifa", nil},
+	}
+
+	for _, test := range tests {
+		query, err := ParseSearchQuery(test.q)
+		if err != nil {
+			t.Fatalf("ParseSearchQuery(%q) failed: %v", test.q, err)
+		}
+		var got []string
+		for _, tweet := range b.Search(query, 10) {
+			got = append(got, tweet.ID)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Search(%q) = %q, want %q", test.q, got, test.want)
+		}
+	}
+
+	// evicting has to drop the bare forms from the index too
+	b.Expire(1)
+	if len(b.postings) != 0 {
+		t.Errorf("postings left after every tweet expired: %v", b.postings)
+	}
+}
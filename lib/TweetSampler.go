@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TweetSample is an example tweet for a word.
+type TweetSample struct {
+	ID        string    `json:"id"`
+	AuthorID  string    `json:"authorId"`
+	Text      string    `json:"text,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// the time it was counted at, which is what decides its chunk
+	at          time.Time
+	priority    float64
+	fingerprint uint64
+}
+
+// Fingerprint is a 64 bit SimHash of the words of a tweet, leaving out links, mentions and "rt".
+// Tweets that differ in a word or two have fingerprints that differ in only a few bits, so retweets and copypasta
+// can be told apart from different tweets about the same thing.
+func Fingerprint(text string) uint64 {
+	var weights [64]int
+	for _, token := range strings.Fields(strings.ToLower(text)) {
+		if token == "rt" || strings.HasPrefix(token, "@") || strings.HasPrefix(token, "http") {
+			continue
+		}
+		hash := fnv.New64a()
+		hash.Write([]byte(token))
+		h := hash.Sum64()
+		for i := 0; i < 64; i++ {
+			if h&(1<<uint(i)) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for i, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << uint(i)
+		}
+	}
+
+	return fingerprint
+}
+
+// TweetSampler keeps up to K example tweets for every candidate word, from the chunks still in the focus window.
+// Every tweet gets a random priority and a word keeps the K tweets with the highest ones, which is a uniform sample of
+// the tweets it was offered as long as none expire. A tweet within MaxDistance bits of a sample the word already has
+// is a near-duplicate and is left out, so one viral tweet doesn't take every slot.
+type TweetSampler struct {
+	K           int
+	MaxDistance int
+	// keep the text of the tweets, not just their IDs
+	KeepText   bool
+	samples    map[string][]TweetSample
+	candidates map[string]bool
+	random     *rand.Rand
+	mutex      sync.Mutex
+}
+
+func NewTweetSampler(k int, maxDistance int, keepText bool) *TweetSampler {
+	s := &TweetSampler{}
+	s.K = k
+	s.MaxDistance = maxDistance
+	s.KeepText = keepText
+	s.samples = make(map[string][]TweetSample)
+	s.candidates = make(map[string]bool)
+	s.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	return s
+}
+
+// SetCandidates replaces the words that get samples from now on. The samples of the other words are kept until they
+// expire, so a word that just left the board still has them.
+func (s *TweetSampler) SetCandidates(words []string) {
+	candidates := make(map[string]bool, len(words))
+	for _, word := range words {
+		candidates[word] = true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.candidates = candidates
+}
+
+// Offer considers a tweet counted at time at as a sample for each of its candidate words.
+// Samples counted before since have left the window and make room for it.
+func (s *TweetSampler) Offer(words []string, id string, authorID string, text string, createdAt time.Time, at time.Time, since time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var sample *TweetSample
+	for _, word := range words {
+		if !s.candidates[word] {
+			continue
+		}
+		// only fingerprint tweets that have a candidate word
+		if sample == nil {
+			sample = &TweetSample{
+				ID:          id,
+				AuthorID:    authorID,
+				CreatedAt:   createdAt,
+				at:          at,
+				priority:    s.random.Float64(),
+				fingerprint: Fingerprint(text),
+			}
+			if s.KeepText {
+				sample.Text = text
+			}
+		}
+		s.offerUnlocked(word, *sample, since)
+	}
+}
+
+func (s *TweetSampler) offerUnlocked(word string, sample TweetSample, since time.Time) {
+	samples := s.samples[word][:0:0]
+	lowest := -1
+	for _, other := range s.samples[word] {
+		if other.ID == sample.ID {
+			// the word is in the tweet more than once
+			return
+		}
+		if other.at.Before(since) {
+			continue
+		}
+		if bits.OnesCount64(other.fingerprint^sample.fingerprint) <= s.MaxDistance {
+			return
+		}
+		samples = append(samples, other)
+		if lowest == -1 || other.priority < samples[lowest].priority {
+			lowest = len(samples) - 1
+		}
+	}
+
+	if len(samples) < s.K {
+		samples = append(samples, sample)
+	} else if sample.priority > samples[lowest].priority {
+		samples[lowest] = sample
+	}
+	s.samples[word] = samples
+}
+
+// Samples returns up to n samples for word counted since since, newest first.
+// The expired ones are left for Prune, so reading doesn't change anything.
+func (s *TweetSampler) Samples(word string, n int, since time.Time) []TweetSample {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	samples := make([]TweetSample, 0, len(s.samples[word]))
+	for _, sample := range s.samples[word] {
+		if !sample.at.Before(since) {
+			samples = append(samples, sample)
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].CreatedAt.After(samples[j].CreatedAt) })
+	if len(samples) > n {
+		samples = samples[:n]
+	}
+
+	return samples
+}
+
+// Prune drops the words that are no longer candidates and have no samples left in the window.
+func (s *TweetSampler) Prune(since time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for word, samples := range s.samples {
+		if s.candidates[word] {
+			continue
+		}
+		live := false
+		for _, sample := range samples {
+			if !sample.at.Before(since) {
+				live = true
+				break
+			}
+		}
+		if !live {
+			delete(s.samples, word)
+		}
+	}
+}
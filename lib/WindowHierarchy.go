@@ -0,0 +1,360 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sync"
+	"time"
+)
+
+// RollupWindow is one window of a WindowHierarchy: the last Span complete buckets of one of its levels.
+type RollupWindow struct {
+	Name   string
+	Level  int
+	Span   int
+	Total  *WordDiff
+	Tweets int64
+}
+
+type rollupLevel struct {
+	period time.Duration
+	// the most buckets any window on this level needs
+	span          int
+	buckets       *CircularQueue
+	current       *IdDiff
+	currentStart  time.Time
+	currentTweets int64
+}
+
+// WindowHierarchy rolls chunks up into rings of buckets of increasing length, e.g. minutes and then hours,
+// and keeps a running total for every window over them, e.g. the last 5 minutes or the last 24 hours.
+// A bucket is only visible to the windows once it is complete, so a window trails real time by at most one bucket.
+type WindowHierarchy struct {
+	levels  []*rollupLevel
+	windows []*RollupWindow
+	mutex   sync.Mutex
+}
+
+// NewWindowHierarchy creates the levels with the given bucket periods (each should divide the next one),
+// and the windows over them. Only Name, Level and Span of the windows are used.
+func NewWindowHierarchy(periods []time.Duration, windows []RollupWindow) *WindowHierarchy {
+	h := &WindowHierarchy{}
+	for _, period := range periods {
+		h.levels = append(h.levels, &rollupLevel{period: period, span: 1, current: NewIdDiff()})
+	}
+	for _, window := range windows {
+		h.windows = append(h.windows, &RollupWindow{
+			Name:  window.Name,
+			Level: window.Level,
+			Span:  window.Span,
+			Total: NewWordDiff(),
+		})
+		if window.Span > h.levels[window.Level].span {
+			h.levels[window.Level].span = window.Span
+		}
+	}
+	for _, level := range h.levels {
+		// one extra slot because a CircularQueue of n holds n-1 elements, and one for the bucket being evicted
+		level.buckets = NewCircularQueue(level.span + 2)
+	}
+
+	return h
+}
+
+func (h *WindowHierarchy) Lock() {
+	h.mutex.Lock()
+}
+
+func (h *WindowHierarchy) Unlock() {
+	h.mutex.Unlock()
+}
+
+// AddChunk counts a chunk of tweets that started at time at.
+func (h *WindowHierarchy) AddChunk(at time.Time, tweets int64, chunk *SealedChunk) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.advanceUnlocked(0, at)
+	h.levels[0].current.AddSealed(chunk)
+	h.levels[0].currentTweets += tweets
+}
+
+// seals buckets of level i until at is inside the current one
+func (h *WindowHierarchy) advanceUnlocked(i int, at time.Time) {
+	level := h.levels[i]
+	if level.currentStart.IsZero() {
+		level.currentStart = at.Truncate(level.period)
+		return
+	}
+
+	for !at.Before(level.currentStart.Add(level.period)) {
+		bucket := &WindowBucket{Start: level.currentStart, Tweets: level.currentTweets, Words: level.current.Seal()}
+		level.currentTweets = 0
+		level.currentStart = level.currentStart.Add(level.period)
+		h.pushUnlocked(i, bucket)
+
+		// after a long gap, only the last span empty buckets matter
+		skipTo := at.Truncate(level.period).Add(-time.Duration(level.span) * level.period)
+		if level.currentStart.Before(skipTo) {
+			level.currentStart = skipTo
+		}
+	}
+}
+
+func (h *WindowHierarchy) pushUnlocked(i int, bucket *WindowBucket) {
+	level := h.levels[i]
+	level.buckets.Enqueue(bucket)
+
+	for _, window := range h.windows {
+		if window.Level != i {
+			continue
+		}
+
+		bucket.Words.AddTo(window.Total)
+		window.Tweets += bucket.Tweets
+		if level.buckets.Len() > window.Span {
+			oldest := level.buckets.At(level.buckets.Len() - 1 - window.Span).(*WindowBucket)
+			oldest.Words.SubFromPruned(window.Total)
+			window.Tweets -= oldest.Tweets
+		}
+	}
+
+	if level.buckets.Len() > level.span {
+		oldest := level.buckets.Dequeue().(*WindowBucket)
+		oldest.Words.Release()
+	}
+
+	// roll the bucket up into the next level
+	if i+1 < len(h.levels) {
+		next := h.levels[i+1]
+		h.advanceUnlocked(i+1, bucket.Start)
+		next.current.AddSealed(bucket.Words)
+		next.currentTweets += bucket.Tweets
+	}
+}
+
+// Window returns the running total of the named window and how many tweets it covers.
+// The total must be locked while reading it, since the next complete bucket updates it.
+func (h *WindowHierarchy) Window(name string) (*WordDiff, int64, bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	for _, window := range h.windows {
+		if window.Name == name {
+			return window.Total, window.Tweets, true
+		}
+	}
+
+	return nil, 0, false
+}
+
+// Duration returns how much time the named window covers.
+func (h *WindowHierarchy) Duration(name string) time.Duration {
+	for _, window := range h.windows {
+		if window.Name == name {
+			return time.Duration(window.Span) * h.levels[window.Level].period
+		}
+	}
+
+	return 0
+}
+
+// Series returns the count of word in every complete bucket of the named window, oldest first, and the length of the
+// buckets. Buckets are aligned to their length, so the series of different words line up.
+func (h *WindowHierarchy) Series(name string, word string) ([]SeriesPoint, time.Duration, bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	for _, window := range h.windows {
+		if window.Name != name {
+			continue
+		}
+
+		level := h.levels[window.Level]
+		id, found := Symbols.Lookup(word)
+		first := level.buckets.Len() - window.Span
+		if first < 0 {
+			first = 0
+		}
+		points := make([]SeriesPoint, 0, level.buckets.Len()-first)
+		for j := first; j < level.buckets.Len(); j++ {
+			bucket, ok := level.buckets.At(j).(*WindowBucket)
+			if !ok {
+				continue
+			}
+			point := SeriesPoint{Start: bucket.Start, Tweets: bucket.Tweets}
+			if found && bucket.Words != nil {
+				point.Count = bucket.Words.GetId(id)
+			}
+			points = append(points, point)
+		}
+
+		return points, level.period, true
+	}
+
+	return nil, 0, false
+}
+
+// Names returns the names of the windows, in the order they were configured.
+func (h *WindowHierarchy) Names() []string {
+	names := make([]string, len(h.windows))
+	for i, window := range h.windows {
+		names[i] = window.Name
+	}
+
+	return names
+}
+
+// WalkIds calls walkFunc for every word ID that a bucket of the hierarchy holds, once per bucket.
+func (h *WindowHierarchy) WalkIds(walkFunc func(uint32)) {
+	h.Lock()
+	defer h.Unlock()
+
+	for _, level := range h.levels {
+		level.buckets.Walk(func(obj interface{}) {
+			obj.(*WindowBucket).Words.Walk(func(id uint32, count int) {
+				walkFunc(id)
+			})
+		})
+		level.current.Walk(func(id uint32, count int) {
+			walkFunc(id)
+		})
+	}
+}
+
+type rollupLevelPublic struct {
+	Period        time.Duration
+	Buckets       []*WindowBucket
+	Current       *SealedChunk
+	CurrentStart  time.Time
+	CurrentTweets int64
+}
+
+type windowHierarchyPublic struct {
+	Levels  []rollupLevelPublic
+	Windows []RollupWindow
+}
+
+// GobEncode lets the hierarchy be part of the backups. The caller must hold the lock.
+// The window totals are not stored, see Restore.
+func (h *WindowHierarchy) GobEncode() ([]byte, error) {
+	p := &windowHierarchyPublic{}
+	for _, level := range h.levels {
+		levelPublic := rollupLevelPublic{
+			Period:        level.period,
+			Current:       newSealedChunk(level.current.Words),
+			CurrentStart:  level.currentStart,
+			CurrentTweets: level.currentTweets,
+		}
+		level.buckets.Walk(func(obj interface{}) {
+			levelPublic.Buckets = append(levelPublic.Buckets, obj.(*WindowBucket))
+		})
+		p.Levels = append(p.Levels, levelPublic)
+	}
+	for _, window := range h.windows {
+		p.Windows = append(p.Windows, RollupWindow{Name: window.Name, Level: window.Level, Span: window.Span})
+	}
+
+	buffer := bytes.NewBuffer([]byte{})
+	err := gob.NewEncoder(buffer).Encode(p)
+
+	return buffer.Bytes(), err
+}
+
+// GobDecode restores the levels and windows as they were when the backup was made. Restore moves them into the
+// current ones.
+func (h *WindowHierarchy) GobDecode(data []byte) error {
+	p := &windowHierarchyPublic{}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(p)
+	if err != nil {
+		return err
+	}
+
+	periods := make([]time.Duration, len(p.Levels))
+	for i, level := range p.Levels {
+		periods[i] = level.Period
+	}
+	fresh := NewWindowHierarchy(periods, p.Windows)
+	h.levels = fresh.levels
+	h.windows = fresh.windows
+
+	for i, levelPublic := range p.Levels {
+		level := h.levels[i]
+		for _, bucket := range levelPublic.Buckets {
+			level.buckets.Enqueue(bucket)
+		}
+		// its IDs already hold references in the restored symbol table
+		if levelPublic.Current != nil {
+			levelPublic.Current.Walk(func(id uint32, count int) {
+				level.current.Words[id] = count
+			})
+		}
+		level.currentStart = levelPublic.CurrentStart
+		level.currentTweets = levelPublic.CurrentTweets
+	}
+
+	return nil
+}
+
+// Restore takes over the buckets of a hierarchy decoded from a backup, for every level whose period is still the
+// same, and rebuilds the window totals from them. The windows stay the ones h was created with, so they can change
+// between restarts. Levels that are gone are released, and new levels start out empty.
+// It has to wait until Symbols is restored, since that is what the buckets' IDs refer to.
+func (h *WindowHierarchy) Restore(backup *WindowHierarchy) {
+	h.Lock()
+	defer h.Unlock()
+
+	taken := make(map[*rollupLevel]bool)
+	for _, level := range h.levels {
+		for _, old := range backup.levels {
+			if old.period != level.period || taken[old] {
+				continue
+			}
+			taken[old] = true
+
+			// keep the newest buckets that fit
+			for old.buckets.Len() > level.span+1 {
+				old.buckets.Dequeue().(*WindowBucket).Words.Release()
+			}
+			old.buckets.Walk(func(obj interface{}) {
+				level.buckets.Enqueue(obj)
+			})
+			level.current = old.current
+			level.currentStart = old.currentStart
+			level.currentTweets = old.currentTweets
+			break
+		}
+	}
+	for _, old := range backup.levels {
+		if taken[old] {
+			continue
+		}
+		old.buckets.Walk(func(obj interface{}) {
+			obj.(*WindowBucket).Words.Release()
+		})
+		old.current.Release()
+	}
+
+	h.rebuildTotalsUnlocked()
+}
+
+// recomputes the window totals from the buckets
+func (h *WindowHierarchy) rebuildTotalsUnlocked() {
+	for _, window := range h.windows {
+		window.Total = NewWordDiff()
+		window.Tweets = 0
+		buckets := h.levels[window.Level].buckets
+		first := buckets.Len() - window.Span
+		if first < 0 {
+			first = 0
+		}
+		for j := first; j < buckets.Len(); j++ {
+			bucket, ok := buckets.At(j).(*WindowBucket)
+			if !ok {
+				continue
+			}
+			bucket.Words.AddTo(window.Total)
+			window.Tweets += bucket.Tweets
+		}
+	}
+}
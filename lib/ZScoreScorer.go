@@ -0,0 +1,162 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+)
+
+// ZScoreScorer ranks words by how many standard deviations their count in the window is above what the baseline
+// expects, instead of by hand tuned thresholds. Only words that are significantly above the baseline at the
+// Significance level (one sided) are ranked at all.
+//
+// The count is modelled as a negative binomial with mean mu = LongRate * WindowTweets and variance
+// mu + Dispersion * mu^2, where the dispersion is estimated from how unevenly the word is spread over the chunks of
+// the window (0, i.e. Poisson, if the chunks are unknown). A word that shows up in a single spammy burst has a
+// high dispersion, and so a lower score than a word that is steadily used more.
+// When mu is below ExactBelow the normal approximation is too rough, so the exact tail probability is converted
+// to a z-score instead.
+type ZScoreScorer struct {
+	Significance float64
+	ExactBelow   float64
+	// need at least this many chunks to estimate the dispersion
+	MinChunks int
+	zCritical float64
+}
+
+func NewZScoreScorer(significance float64, exactBelow float64, minChunks int) *ZScoreScorer {
+	s := &ZScoreScorer{}
+	s.Significance = significance
+	s.ExactBelow = exactBelow
+	s.MinChunks = minChunks
+	s.zCritical = zFromTail(significance)
+
+	return s
+}
+
+// converts a one sided tail probability into the z-score with the same tail under the standard normal
+func zFromTail(p float64) float64 {
+	return math.Sqrt2 * math.Erfcinv(2*p)
+}
+
+// Dispersion estimates the negative binomial dispersion of a word from its count in every chunk,
+// with the method of moments around the word's average rate over the chunks.
+func (s *ZScoreScorer) Dispersion(word string, chunks []*Chunk) float64 {
+	if len(chunks) < s.MinChunks {
+		return 0
+	}
+	id, ok := Symbols.Lookup(word)
+	if !ok {
+		return 0
+	}
+
+	counts := make([]float64, len(chunks))
+	var total float64
+	var tweets int64
+	for i, chunk := range chunks {
+		if chunk.Words != nil {
+			counts[i] = float64(chunk.Words.GetId(id))
+		}
+		total += counts[i]
+		tweets += chunk.Tweets
+	}
+	if tweets == 0 {
+		return 0
+	}
+
+	rate := total / float64(tweets)
+	var excess, squares float64
+	for i, chunk := range chunks {
+		mean := rate * float64(chunk.Tweets)
+		excess += (counts[i]-mean)*(counts[i]-mean) - mean
+		squares += mean * mean
+	}
+	if squares == 0 || excess <= 0 {
+		return 0
+	}
+
+	return excess / squares
+}
+
+// logUpperTail returns log P(X >= k) for a negative binomial with the given mean and dispersion (Poisson if it is 0).
+// The terms are summed up from k relative to the first one, so it stays accurate for probabilities that would
+// underflow a float64.
+func logUpperTail(k int, mu float64, dispersion float64) float64 {
+	var logTerm float64
+	var ratio func(j int) float64
+	if dispersion == 0 {
+		logTerm = float64(k)*math.Log(mu) - mu - lgamma(float64(k)+1)
+		ratio = func(j int) float64 { return mu / float64(j+1) }
+	} else {
+		r := 1 / dispersion
+		p := r / (r + mu)
+		logTerm = lgamma(float64(k)+r) - lgamma(r) - lgamma(float64(k)+1) + r*math.Log(p) + float64(k)*math.Log(1-p)
+		ratio = func(j int) float64 { return (float64(j) + r) / float64(j+1) * (1 - p) }
+	}
+
+	term := 1.0
+	sum := term
+	for j := k; j < k+100000 && term > sum*1e-12; j++ {
+		term *= ratio(j)
+		sum += term
+	}
+
+	return math.Min(logTerm+math.Log(sum), 0)
+}
+
+// converts log P(Z >= z) back into z. Erfcinv loses all precision below ~1e-16 (it works on 1-p),
+// so past ~7 standard deviations use the asymptotic expansion of the normal tail.
+func zFromLogTail(logP float64) float64 {
+	if logP > -30 {
+		return zFromTail(math.Exp(logP))
+	}
+
+	x := -2 * logP
+	return math.Sqrt(x - math.Log(x) - math.Log(2*math.Pi))
+}
+
+func lgamma(x float64) float64 {
+	v, _ := math.Lgamma(x)
+	return v
+}
+
+func (s *ZScoreScorer) z(in ScoreInput, dispersion float64) float64 {
+	mu := float64(in.Expected())
+	if mu < s.ExactBelow {
+		return zFromLogTail(logUpperTail(in.Count, mu, dispersion))
+	}
+
+	return (float64(in.Count) - mu) / math.Sqrt(mu+dispersion*mu*mu)
+}
+
+func (s *ZScoreScorer) Score(in ScoreInput) (WordScore, bool) {
+	mu := float64(in.Expected())
+	if in.LongRate == 0 || float64(in.Count) <= mu+1 {
+		return WordScore{}, false
+	}
+
+	// the dispersion only makes the variance bigger, so check the cheap Poisson score first
+	z := s.z(in, 0)
+	if z < s.zCritical {
+		return WordScore{}, false
+	}
+	if len(in.Chunks) > 0 {
+		z = s.z(in, s.Dispersion(in.Word, in.Chunks))
+		if z < s.zCritical {
+			return WordScore{}, false
+		}
+	}
+
+	return WordScore{Score: float32(z), AdjustedCount: in.Count - int(mu), Multiple: float32(float64(in.Count) / mu)}, true
+}
+
+func (s *ZScoreScorer) Explain(in ScoreInput, score WordScore) string {
+	mu := float64(in.Expected())
+	dispersion := s.Dispersion(in.Word, in.Chunks)
+	model := "normal approximation"
+	if mu < s.ExactBelow {
+		model = "exact tail"
+	}
+
+	return fmt.Sprintf("used %d times against an expected %.1f (dispersion %.3f over %d chunks): z = %.2f by %s, needs %.2f",
+		in.Count, mu, dispersion, len(in.Chunks), score.Score, model, s.zCritical)
+}
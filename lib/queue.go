@@ -67,6 +67,22 @@ func (q *CircularQueue) Dequeue() interface{} {
 	return v
 }
 
+// First returns the element at the head of the queue without removing it
+func (q *CircularQueue) First() interface{} {
+	if q.IsEmpty() {
+		return nil
+	}
+	return q.data[q.head]
+}
+
+// At returns the i'th element counting from the head, or nil if there are not that many elements
+func (q *CircularQueue) At(i int) interface{} {
+	if i < 0 || i >= q.Len() {
+		return nil
+	}
+	return q.data[(q.head+i)%q.capacity]
+}
+
 func (q *CircularQueue) Last() interface{} {
 	if q.IsEmpty() {
 		return nil
@@ -78,6 +94,18 @@ func (q *CircularQueue) Last() interface{} {
 	}
 }
 
+// Len returns the number of elements in the queue
+func (q *CircularQueue) Len() int {
+	return (q.tail - q.head + q.capacity) % q.capacity
+}
+
+// Walk calls walkFunc on every element, from the head (oldest) to the tail (newest)
+func (q *CircularQueue) Walk(walkFunc func(interface{})) {
+	for i := q.head; i != q.tail; i = (i + 1) % q.capacity {
+		walkFunc(q.data[i])
+	}
+}
+
 // String prints the queue
 func (q *CircularQueue) String() string {
 	if q.IsEmpty() {
@@ -8,6 +8,9 @@ import (
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"cloud.google.com/go/translate"
 	"github.com/CalderWhite/top-tweets/lib"
@@ -21,9 +24,26 @@ import (
 var translateCache map[string]string = make(map[string]string)
 
 type WordPair struct {
-	Word        string `json:"word"`
+	Word string `json:"word"`
+	// how the word is most often written in the focus window
+	Display     string `json:"display"`
 	Count       int    `json:"count"`
 	Translation string `json:"translation"`
+	// example tweets from the focus window
+	Samples []lib.TweetSample `json:"samples,omitempty"`
+}
+
+// parseTimeParam parses a query param that is either a time (RFC 3339) or a go duration before now.
+func parseTimeParam(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	ago, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return now.Add(-ago), nil
 }
 
 func translateText(targetLanguage, text string) (string, error) {
@@ -56,6 +76,7 @@ func translateText(targetLanguage, text string) (string, error) {
  */
 
 func main() {
+	loadConfig()
 	prod := os.Getenv("TOP_TWEETS_MODE") == "PRODUCTION"
 	r := gin.New()
 	r.Use(gzip.Gzip(gzip.DefaultCompression,
@@ -122,6 +143,14 @@ func main() {
 	 * Gets the top [limit] words (default 100), adjusted by the longGlobalDiff.
 	 * This adjustment allows top to produce emerging and interesting words, instead of
 	 * stopwords like "the" or "los" (in spanish), etc.
+	 * window = [ focus | 1m | 5m | 1h | 24h ]
+	 * Every window other than [focus] is built from complete minute/hour buckets.
+	 * samples = up to [samples] example tweets per word (none by default), always from the focus window.
+	 * sparkline = the word's count over the focus window in [sparkline] points (none by default), oldest first.
+	 * The focus window with the default scorer is served from a smoothed board, so words near the cut-off don't
+	 * flicker in and out. raw = [ false | true ] skips it for the ranking as it is right now.
+	 * The board only holds the best 100 words, so a [limit] above that always gets the raw ranking.
+	 * explain = [ false | true ] adds how the scorer came up with every word's score.
 	 */
 	api.GET("/words/top", func(c *gin.Context) {
 		q := c.Request.URL.Query()
@@ -133,20 +162,107 @@ func main() {
 		} else {
 			var err error
 			limit, err = strconv.Atoi(limitParam[0])
+			if err != nil || limit <= 0 {
+				c.JSON(400, gin.H{
+					"message": "Error! Limit query param must be a positive integer.",
+				})
+				return
+			}
+		}
+
+		samples := 0
+		if samplesParam, found := q["samples"]; found {
+			var err error
+			samples, err = strconv.Atoi(samplesParam[0])
+			if err != nil || samples < 0 {
+				c.JSON(400, gin.H{
+					"status":  "error",
+					"code":    400,
+					"message": "Samples parameter must be a non-negative integer.",
+				})
+				return
+			}
+		}
+
+		sparkline := 0
+		if sparklineParam, found := q["sparkline"]; found {
+			var err error
+			sparkline, err = strconv.Atoi(sparklineParam[0])
+			if err != nil || sparkline < 0 {
+				c.JSON(400, gin.H{
+					"status":  "error",
+					"code":    400,
+					"message": "Sparkline parameter must be a non-negative integer.",
+				})
+				return
+			}
+		}
+
+		raw := false
+		if rawParam, found := q["raw"]; found {
+			var err error
+			raw, err = strconv.ParseBool(rawParam[0])
+			if err != nil {
+				c.JSON(400, gin.H{
+					"status":  "error",
+					"code":    400,
+					"message": "Raw parameter must be 'true' or 'false'.",
+				})
+				return
+			}
+		}
+
+		explain := false
+		if explainParam, found := q["explain"]; found {
+			var err error
+			explain, err = strconv.ParseBool(explainParam[0])
 			if err != nil {
 				c.JSON(400, gin.H{
-					"message": "Error! Limit query param must be integer.",
+					"status":  "error",
+					"code":    400,
+					"message": "Explain parameter must be 'true' or 'false'.",
 				})
 				return
 			}
 		}
 
+		scorerName := config().Scorer
+		if scorerParam, found := q["scorer"]; found {
+			scorerName = scorerParam[0]
+		}
+		scorer, ok := getScorer(scorerName)
+		if !ok {
+			c.JSON(400, gin.H{
+				"status":  "error",
+				"code":    400,
+				"message": fmt.Sprintf("Scorer parameter must be one of '%s'.", strings.Join(scorerNames(), "', '")),
+			})
+			return
+		}
+
 		var words []WordRankingPair
-		if limit == 100 {
-			words = make([]WordRankingPair, len(topCache))
-			copy(words, topCache)
-		} else {
+		windowStart := focusWindowStart()
+		explainDiff, explainTweets := globalDiff, atomic.LoadInt64(&focusTweetCount)
+		windowParam, windowFound := q["window"]
+		if windowFound && windowParam[0] != "focus" {
+			windowDiff, windowTweets, ok := rollupWindows.Window(windowParam[0])
+			if !ok {
+				c.JSON(400, gin.H{
+					"status":  "error",
+					"code":    400,
+					"message": fmt.Sprintf("Window parameter must be one of 'focus', '%s'.", strings.Join(rollupWindows.Names(), "', '")),
+				})
+				return
+			}
+			words = getTopFor(windowDiff, windowTweets, limit, scorer)
+			windowStart = time.Now().Add(-rollupWindows.Duration(windowParam[0]))
+			explainDiff, explainTweets = windowDiff, windowTweets
+		} else if scorerName == config().Scorer && !raw && limit <= boardSize {
+			words = getBoard(limit)
+		} else if scorerName == config().Scorer {
 			words = getTop(limit)
+		} else {
+			words = getTopFor(globalDiff, atomic.LoadInt64(&focusTweetCount), limit, scorer)
 		}
 		// reverse words so highest is first.
 		for i, j := 0, len(words)-1; i < j; i, j = i+1, j-1 {
@@ -154,15 +270,265 @@ func main() {
 		}
 
 		for i, wordPair := range words {
+			words[i].Display = getDisplay(wordPair.Word)
 			translation, foundTranslation := translateCache[wordPair.Word]
 			if foundTranslation {
 				words[i].Translation = translation
 			}
+			if samples > 0 {
+				words[i].Samples = getSamples(wordPair.Word, samples)
+			}
+		}
+		if sparkline > 0 {
+			addSparklines(words, sparkline)
+		}
+		if explain {
+			addExplanations(words, explainDiff, explainTweets, scorer)
 		}
 
 		c.JSON(200, gin.H{
 			"words": words,
 			"total": globalTweetCount,
+			// outages in the window, since the counts will be off while the stream was down
+			"gaps": gapDetector.Recent(windowStart),
+		})
+	})
+
+	/**
+	 * Reports the state of the stream: how many tweets we have seen, the last chunk,
+	 * and the gaps in the stream over the last [since] (a go duration, 24h by default).
+	 */
+	api.GET("/status", func(c *gin.Context) {
+		q := c.Request.URL.Query()
+		since := 24 * time.Hour
+		if sinceParam, found := q["since"]; found {
+			var err error
+			since, err = time.ParseDuration(sinceParam[0])
+			if err != nil {
+				c.JSON(400, gin.H{
+					"status":  "error",
+					"code":    400,
+					"message": "Since parameter must be a duration like '24h'.",
+				})
+				return
+			}
+		}
+
+		gapDetector.Lock()
+		usualRate := gapDetector.Rate
+		gapDetector.Unlock()
+
+		c.JSON(200, gin.H{
+			"total":               globalTweetCount,
+			"focusTotal":          atomic.LoadInt64(&focusTweetCount),
+			"focusStart":          focusWindowStart(),
+			"chunkSeq":            atomic.LoadUint64(&chunkSeq),
+			"usualTweetsPerChunk": usualRate,
+			"gaps":                gapDetector.Recent(time.Now().Add(-since)),
+		})
+	})
+
+	/**
+	 * Lists the words that burst in the last [since] (a go duration, 24h by default) or are bursting now,
+	 * optionally only those of [word].
+	 */
+	api.GET("/bursts", func(c *gin.Context) {
+		q := c.Request.URL.Query()
+		since := 24 * time.Hour
+		if sinceParam, found := q["since"]; found {
+			var err error
+			since, err = time.ParseDuration(sinceParam[0])
+			if err != nil {
+				c.JSON(400, gin.H{
+					"status":  "error",
+					"code":    400,
+					"message": "Since parameter must be a duration like '24h'.",
+				})
+				return
+			}
+		}
+		word := ""
+		if wordParam, found := q["word"]; found {
+			word = wordParam[0]
+		}
+
+		c.JSON(200, gin.H{
+			"bursts": bursts.Recent(time.Now().Add(-since), word),
+		})
+	})
+
+	/**
+	 * Lists the words that were trending (in the top 50) at some point between [since] (24h ago by default) and
+	 * [until] (now by default), with when they entered the top list, peaked and left it. Optionally only those of [word].
+	 * [since] and [until] are either times like 2021-11-05T13:00:00Z or go durations before now like 24h.
+	 */
+	api.GET("/trends/history", func(c *gin.Context) {
+		q := c.Request.URL.Query()
+		now := time.Now()
+		since := now.Add(-24 * time.Hour)
+		until := now
+		for name, value := range map[string]*time.Time{"since": &since, "until": &until} {
+			param, found := q[name]
+			if !found {
+				continue
+			}
+			parsed, err := parseTimeParam(param[0], now)
+			if err != nil {
+				c.JSON(400, gin.H{
+					"status":  "error",
+					"code":    400,
+					"message": fmt.Sprintf("%s parameter must be a time like '2021-11-05T13:00:00Z' or a duration like '24h'.", strings.Title(name)),
+				})
+				return
+			}
+			*value = parsed
+		}
+		word := ""
+		if wordParam, found := q["word"]; found {
+			word = sanatizeWord(wordParam[0])
+		}
+
+		c.JSON(200, gin.H{
+			"trends": trends.History(since, until, word),
+		})
+	})
+
+	/**
+	 * Ranks words by how fast their rate is changing, comparing the last [span] (a go duration, 1m by default)
+	 * to the one before it. /words/rising has the words taking off, /words/falling the ones fading away.
+	 */
+	momentumHandler := func(rising bool) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			q := c.Request.URL.Query()
+			limit := 100
+			if limitParam, found := q["limit"]; found {
+				var err error
+				limit, err = strconv.Atoi(limitParam[0])
+				if err != nil || limit <= 0 {
+					c.JSON(400, gin.H{
+						"status":  "error",
+						"code":    400,
+						"message": "Limit parameter must be a positive integer.",
+					})
+					return
+				}
+			}
+
+			span := defaultMomentumSpan
+			if spanParam, found := q["span"]; found {
+				var err error
+				span, err = time.ParseDuration(spanParam[0])
+				focusPeriod := time.Duration(config().FocusPeriod) * CHUNK_PERIOD
+				if err != nil || span < CHUNK_PERIOD || 3*span > focusPeriod {
+					c.JSON(400, gin.H{
+						"status":  "error",
+						"code":    400,
+						"message": fmt.Sprintf("Span parameter must be a duration between %s and %s.", CHUNK_PERIOD, focusPeriod/3),
+					})
+					return
+				}
+			}
+
+			words := getMomentum(int(span/CHUNK_PERIOD), rising, limit)
+			c.JSON(200, gin.H{
+				"words": words,
+				"span":  span.String(),
+			})
+		}
+	}
+	api.GET("/words/rising", momentumHandler(true))
+	api.GET("/words/falling", momentumHandler(false))
+
+	/**
+	 * Groups the top [words] (100 by default) words of the focus window into stories of words that are used together,
+	 * and returns the best [limit] (20 by default) stories.
+	 */
+	api.GET("/stories", func(c *gin.Context) {
+		q := c.Request.URL.Query()
+		limit := 20
+		words := 100
+		for name, value := range map[string]*int{"limit": &limit, "words": &words} {
+			param, found := q[name]
+			if !found {
+				continue
+			}
+			parsed, err := strconv.Atoi(param[0])
+			if err != nil || parsed <= 0 {
+				c.JSON(400, gin.H{
+					"status":  "error",
+					"code":    400,
+					"message": fmt.Sprintf("%s parameter must be a positive integer.", strings.Title(name)),
+				})
+				return
+			}
+			*value = parsed
+		}
+
+		stories := getStories(words)
+		if len(stories) > limit {
+			stories = stories[:limit]
+		}
+
+		c.JSON(200, gin.H{
+			"stories": stories,
+		})
+	})
+
+	/**
+	 * Searches the text of the tweets in the focus window, and returns the newest [limit] (20 by default) that match [q].
+	 * Words next to each other must all be in the tweet, "quoted phrases" must appear as written, and OR gives
+	 * alternatives, e.g. q = "world cup" final OR worldcup
+	 * worldcup also matches #worldcup (and @worldcup), while #worldcup only matches the hashtag.
+	 * 404s when the searchTweets setting is 0.
+	 */
+	api.GET("/tweets/search", func(c *gin.Context) {
+		if tweetBuffer == nil {
+			c.JSON(404, gin.H{
+				"status":  "error",
+				"code":    404,
+				"message": "Search is turned off, see the searchTweets setting.",
+			})
+			return
+		}
+		q := c.Request.URL.Query()
+		queryParam, found := q["q"]
+		if !found {
+			c.JSON(400, gin.H{
+				"status":  "error",
+				"code":    400,
+				"message": "You must provide a <q> in the query string.",
+			})
+			return
+		}
+		query, err := lib.ParseSearchQuery(queryParam[0])
+		if err != nil {
+			c.JSON(400, gin.H{
+				"status":  "error",
+				"code":    400,
+				"message": fmt.Sprintf("Invalid query: %s.", err),
+			})
+			return
+		}
+
+		limit := defaultSearchLimit
+		if limitParam, found := q["limit"]; found {
+			limit, err = strconv.Atoi(limitParam[0])
+			if err != nil || limit <= 0 || limit > maxSearchLimit {
+				c.JSON(400, gin.H{
+					"status":  "error",
+					"code":    400,
+					"message": fmt.Sprintf("Limit parameter must be an integer between 1 and %d.", maxSearchLimit),
+				})
+				return
+			}
+		}
+
+		searched, since := tweetBuffer.Len()
+		c.JSON(200, gin.H{
+			"tweets": tweetBuffer.Search(query, limit),
+			// how many tweets were searched, going back to [since]
+			"searched": searched,
+			"since":    since,
 		})
 	})
 
@@ -200,13 +566,13 @@ func main() {
 			}
 		} else if period[0] == "long" {
 			if targetCountFound {
-				longGlobalDiff.Walk(func(word string, count int) {
+				longPeriodCounter().Walk(func(word string, count int) {
 					if int64(count) == targetCount {
 						total++
 					}
 				})
 			} else {
-				longGlobalDiff.Walk(func(word string, count int) {
+				longPeriodCounter().Walk(func(word string, count int) {
 					total++
 				})
 			}
@@ -225,9 +591,11 @@ func main() {
 	})
 
 	/**
-	 * Returns the count for the given [:word].
+	 * Returns the count for the given [:word], which is case-insensitive, and [display], how it is most often written.
 	 * period = [ focus | long ]
-	 * For the [long] period, we use longGlobalDiff. For [focus] we use globalDiff.
+	 * For the [long] period, we use longGlobalDiff (or the sliding window when TOP_TWEETS_BASELINE=window).
+	 * For [focus] we use globalDiff.
+	 * samples = up to [samples] example tweets from the focus window (3 by default). Only trending words have them.
 	 */
 	api.GET("/word", func(c *gin.Context) {
 		q := c.Request.URL.Query()
@@ -240,8 +608,22 @@ func main() {
 			})
 			return
 		}
-		word := wordList[0]
+		// lookups are case-insensitive, like the counts
+		word := sanatizeWord(wordList[0])
 		period, periodFound := q["period"]
+		samples := 3
+		if samplesParam, found := q["samples"]; found {
+			var err error
+			samples, err = strconv.Atoi(samplesParam[0])
+			if err != nil || samples < 0 {
+				c.JSON(400, gin.H{
+					"status":  "error",
+					"code":    400,
+					"message": "Samples parameter must be a non-negative integer.",
+				})
+				return
+			}
+		}
 
 		translation, foundTranslation := translateCache[word]
 		tText := ""
@@ -253,15 +635,19 @@ func main() {
 			count := globalDiff.Get(word)
 			c.JSON(200, WordPair{
 				Word:        word,
+				Display:     getDisplay(word),
 				Count:       count,
 				Translation: tText,
+				Samples:     getSamples(word, samples),
 			})
 		} else if period[0] == "long" {
-			count := longGlobalDiff.Get(word)
+			count := longPeriodCounter().Get(word)
 			c.JSON(200, WordPair{
 				Word:        word,
+				Display:     getDisplay(word),
 				Count:       count,
 				Translation: tText,
+				Samples:     getSamples(word, samples),
 			})
 		} else {
 			c.JSON(400, gin.H{
@@ -273,6 +659,100 @@ func main() {
 		}
 	})
 
+	/**
+	 * Returns how many times [word] was used in every chunk of the focus window, or every bucket of one of the rollup
+	 * windows, oldest first. Each point also has how many tweets there were in total, to turn the counts into rates.
+	 * window = [ focus | 1m | 5m | 1h | 24h ]
+	 */
+	api.GET("/word/series", func(c *gin.Context) {
+		q := c.Request.URL.Query()
+		wordParam, found := q["word"]
+		if !found {
+			c.JSON(400, gin.H{
+				"status":  "error",
+				"code":    400,
+				"message": "You must provide a <word> in the query string.",
+			})
+			return
+		}
+		window := "focus"
+		if windowParam, found := q["window"]; found {
+			window = windowParam[0]
+		}
+
+		series, period, ok := getSeries(window, sanatizeWord(wordParam[0]))
+		if !ok {
+			c.JSON(400, gin.H{
+				"status":  "error",
+				"code":    400,
+				"message": fmt.Sprintf("Window parameter must be one of 'focus', '%s'.", strings.Join(rollupWindows.Names(), "', '")),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"word":   sanatizeWord(wordParam[0]),
+			"window": window,
+			// the length of every point, in seconds
+			"period": period.Seconds(),
+			"series": series,
+		})
+	})
+
+	/**
+	 * Returns the [limit] (20 by default) words that recent tweets use the most together with [word], ranked by
+	 * [by]: "lift" (default) compares how often they show up with [word] to how often they show up in general,
+	 * "count" is just how many tweets used both.
+	 */
+	api.GET("/word/related", func(c *gin.Context) {
+		q := c.Request.URL.Query()
+		wordParam, found := q["word"]
+		if !found {
+			c.JSON(400, gin.H{
+				"status":  "error",
+				"code":    400,
+				"message": "Word parameter is required.",
+			})
+			return
+		}
+		limit := 20
+		if limitParam, found := q["limit"]; found {
+			var err error
+			limit, err = strconv.Atoi(limitParam[0])
+			if err != nil || limit < 0 {
+				c.JSON(400, gin.H{
+					"status":  "error",
+					"code":    400,
+					"message": "Limit parameter must be a positive integer.",
+				})
+				return
+			}
+		}
+		byCount := false
+		if byParam, found := q["by"]; found {
+			switch byParam[0] {
+			case "lift":
+			case "count":
+				byCount = true
+			default:
+				c.JSON(400, gin.H{
+					"status":  "error",
+					"code":    400,
+					"message": "By parameter must be 'lift' or 'count'.",
+				})
+				return
+			}
+		}
+
+		word := sanatizeWord(wordParam[0])
+		related, tweets, _ := getRelated(word, limit, byCount)
+		c.JSON(200, gin.H{
+			"word":    word,
+			"tweets":  tweets,
+			"related": related,
+		})
+	})
+
 	/*
 	 * Produces a protobuf serialized snapshot of the current globalDiff or longGlobalDiff.
 	 * period = [ focus | long ]
@@ -287,7 +767,7 @@ func main() {
 		if !periodFound || period[0] == "focus" {
 			c.Data(200, "application", globalDiff.Serialize())
 		} else if period[0] == "long" {
-			c.Data(200, "application", longGlobalDiff.Serialize())
+			c.Data(200, "application", longPeriodCounter().Serialize())
 		} else {
 			c.JSON(400, gin.H{
 				"status":  "error",
@@ -297,10 +777,24 @@ func main() {
 		}
 	})
 
+	/*
+	 * Produces a gob serialized lib.ChunkRecord of the latest chunk: its words along with its
+	 * sequence number, tweet count and timestamps.
+	 *
+	 * NOTE: The returned data is binary.
+	 */
 	api.GET("/chunks/last", func(c *gin.Context) {
-		diff, ok := wordDiffQueue.Last().(lib.WordDiff)
+		wordDiffQueueMutex.Lock()
+		chunk, ok := wordDiffQueue.Last().(*lib.Chunk)
 		if ok {
-			c.Data(200, "application", diff.Serialize())
+			chunk.Retain()
+		}
+		wordDiffQueueMutex.Unlock()
+		if ok {
+			// the sidecar expects words, not IDs
+			record := chunk.Record()
+			chunk.Release()
+			c.Data(200, "application", record.Serialize())
 		} else {
 			c.JSON(500, gin.H{
 				"status":  "error",
@@ -310,6 +804,15 @@ func main() {
 		}
 	})
 
+	/*
+	 * Produces a gob serialized snapshot of the symbol table that the chunks' word IDs refer to.
+	 *
+	 * NOTE: The returned data is binary.
+	 */
+	api.GET("/symbols", func(c *gin.Context) {
+		c.Data(200, "application", lib.Symbols.Serialize())
+	})
+
 	api.GET("/chunks/stream", func(c *gin.Context) {
 		c.Stream(func(w io.Writer) bool {
 			<-chunkUpdateChannel
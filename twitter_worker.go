@@ -6,13 +6,19 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -34,34 +40,137 @@ import (
  * without this method.
  */
 
-// the amount of tweets required to trigger a push to the wordDiffQueue
-var AGG_SIZE int = 300
-
-// the number of AGG_SIZE tweet blocks that will be considered at one time. Once exceeded, we will start deleteing blocks
+// these come from lib.Config, see loadConfig. CHUNK_PERIOD only changes with a restart, and FOCUS_PERIOD is only
+// changed by the processTweets goroutine when it resizes the wordDiffQueue.
 /**
- * (100) * (100) -- last 4 minutes
- * (300) * (100) -- last 12 minutes
- * (300) * (300) -- last 36 minutes
+ * (300) * (1s) -- last 5 minutes
+ * (300) * (3s) -- last 15 minutes
+ * (300) * (10s) -- last 50 minutes
  *
  */
-var FOCUS_PERIOD int = 300
+var CHUNK_PERIOD time.Duration = lib.DefaultConfig().ChunkPeriod
+var FOCUS_PERIOD int = lib.DefaultConfig().FocusPeriod
+
+// the current lib.Config, swapped as a whole on a reload. Read it with config().
+var configValue atomic.Value
+var configLoader *lib.ConfigLoader = &lib.ConfigLoader{}
+
+// the focus period a reload asked for, applied by the processTweets goroutine at the next chunk. Updated with sync/atomic.
+var pendingFocusPeriod int64
+
+// set when the top index has to be rebuilt from scratch, e.g. because the scoring changed. Updated with sync/atomic.
+var topIndexStale int32
+
+// Setting longCounter to approx replaces the exact longGlobalDiff with a fixed memory lib.ApproxCounter.
+// With these values it takes ~22MB for the sketch and a few MB for the heavy hitters, no matter how many words we see.
+// Long counts are then overestimated by at most approxEpsilon * (words counted so far) with probability 1 - approxDelta,
+// e.g. by ~200 after 100M words. Only the approxTopK most frequent words can be enumerated.
+const (
+	approxEpsilon float64 = 2e-6
+	approxDelta   float64 = 0.02
+	approxTopK    int     = 50000
+)
+
+// Setting baseline to decayed compares the focus window against an exponentially decayed rate instead of
+// the count since the process first started, so old vocabulary fades and new words become "normal" over time.
+// halfLife sets how quickly that happens. Words whose decayed count falls below decayedPruneMin are pruned with the
+// rest of the long counts.
+const decayedPruneMin float64 = 1
 
-// after this many tweets, we will prune all (1) counts in longGlobalDiff, and (0) counts in globalDiff
-// 0 counts have literally no impact, and 1 counts have an infinitesimal impact on the longGlobalDiff when divided by
-// the globalTweetCount
-// we use 360k because we get a min of 40 tweets/second, which means this will prune every 2.5 hours
-// this means more memory usage, but also more accurate long term averages since it will pick up less frequent words
-const longPrunePeriod int = 360000
+// Setting baseline to window compares the focus window against a true sliding window of the last longWindow,
+// built out of longBucketPeriod buckets. The [long] period of the API then means that window too.
+const longBucketPeriod = time.Hour
 
-// we can have a shorter prune period for the short term pruning since it just prunes 0s
-const focusPrunePeriod int = 10000
+// a chunk with less than gapMinRatio of the usual number of tweets is part of a gap in the stream.
+// the usual number is a moving average where each new chunk has a weight of gapAlpha.
+const (
+	gapMinRatio   float64 = 0.25
+	gapAlpha      float64 = 0.01
+	maxRecentGaps int     = 100
+)
+
+// the z-score scorer only ranks words whose count has less than this chance of happening at the baseline rate.
+// there are ~100k words in a window, so this lets through a handful by chance.
+// below zScoreExactBelow expected uses, the tail probability is computed exactly instead of approximated.
+const (
+	zScoreSignificance float64 = 1e-6
+	zScoreExactBelow   float64 = 30
+	zScoreMinChunks    int     = 10
+)
+
+// a word is bursting in state s when it is used burstBase^s times more than usual. Entering a burst costs
+// burstGamma * ln(FOCUS_PERIOD), so it takes a few chunks of evidence.
+// words are tracked once they are used burstMinCount times in a chunk at burstBase times their usual rate,
+// and forgotten after a focus window without a burst. At most burstMaxWords words are tracked at a time.
+const (
+	burstLevels     int     = 3
+	burstBase       float64 = 2
+	burstGamma      float64 = 1
+	burstMinCount   int     = 3
+	burstMaxWords   int     = 20000
+	burstMinRate    float64 = 1e-7
+	maxRecentBursts int     = 1000
+)
+
+// by default, /api/words/rising and falling compare the last minute to the one before it.
+// a word needs momentumMinCount uses in those two minutes to be considered.
+const (
+	defaultMomentumSpan time.Duration = time.Minute
+	momentumMinCount    int           = 20
+)
 
-// not sure what to base this number off. Maybe use things like all time std dev, etc.
+// two trending words are part of the same story when at least storyMinPairs tweets use both,
+// and those are at least storyMinOverlap of the tweets of the less used one.
 const (
-	minMultiple      float32 = 2
-	maxMultiple      float32 = 15
-	minCount         float32 = 100
-	maxAdjustedCount float32 = 3000
+	storyMinPairs   int     = 5
+	storyMinOverlap float64 = 0.2
+)
+
+// /api/word/related keeps the neighbors of up to relatedMaxWords recently used words, relatedMaxNeighbors each.
+// that is around 20MB. Counts halve every relatedHalfLife chunks (5 minutes of 3s chunks), and only the first
+// relatedMaxTweetWords words of a tweet are paired.
+const (
+	relatedMaxWords      int     = 20000
+	relatedMaxNeighbors  int     = 32
+	relatedHalfLife      float64 = 100
+	relatedMaxTweetWords int     = 32
+	relatedMinRate       float64 = 1e-7
+	relatedMinCount      float64 = 3
+)
+
+// every candidate word keeps up to sampleSize example tweets from the focus window, for ?samples=.
+// a tweet within sampleMaxDistance bits of one the word already has is a near-duplicate (retweets, copypasta).
+// Setting sampleText to false only keeps the IDs, which is enough to embed the tweets.
+const (
+	sampleSize        int = 10
+	sampleMaxDistance int = 3
+)
+
+// /api/tweets/search searches the tweets of the focus window, up to the SearchTweets setting of them.
+// They are not part of the backups, so the search starts out empty after a restart.
+const (
+	defaultSearchLimit int = 20
+	maxSearchLimit     int = 1000
+)
+
+// the best trendTopSize words of the top list are trending. A word stops trending once it has been off that list
+// for trendExitAfter, and the last maxTrends trends that ended are kept for /api/trends/history.
+const (
+	trendTopSize   int           = 50
+	trendExitAfter time.Duration = 2 * time.Minute
+	maxTrends      int           = 10000
+)
+
+// how words are written is counted for at most maxDisplayForms forms at a time, e.g. "NASA" for "nasa".
+const maxDisplayForms int = 200000
+
+// hashtags are split into words of at most hashtagMaxWordLength runes. Runs of letters shorter than
+// hashtagMinSplitLength are taken as one word, and words rarer than hashtagMinRate are unknown.
+const (
+	hashtagMinRate        float64 = 1e-7
+	hashtagMaxWordLength  int     = 20
+	hashtagMinSplitLength int     = 6
+	hashtagMaxCached      int     = 100000
 )
 
 const recoveryFileName = "backups/top_tweets_recovery.dat"
@@ -76,11 +185,19 @@ type StreamDataSchema struct {
 }
 
 type WordRankingPair struct {
-	Word        string  `json:"word"`
+	Word string `json:"word"`
+	// how the word is most often written, e.g. "NASA" for "nasa"
+	Display     string  `json:"display"`
 	Translation string  `json:"translation"`
 	Count       int     `json:"count"`
 	Multiple    float32 `json:"multiple"`
 	WordScore   float32 `json:"wordScore"`
+	// how the scorer came up with WordScore
+	Explanation string `json:"explanation,omitempty"`
+	// example tweets that use the word, with ?samples=
+	Samples []lib.TweetSample `json:"samples,omitempty"`
+	// the word's count over the focus window, with ?sparkline=
+	Sparkline []int `json:"sparkline,omitempty"`
 }
 
 // we could use the database for this, but this gobbing this struct
@@ -89,19 +206,281 @@ type WordRankingPair struct {
 type RecoveryPoint struct {
 	GlobalTweetCount int64
 	LongDiff         *lib.WordDiff
+	LongApprox       *lib.ApproxCounter
 	FocusDiff        *lib.WordDiff
+	FocusTweetCount  int64
+	// only in backups from before chunks were cut by time, where every chunk had AggSize tweets
 	AggSize          int
+	ChunkPeriod      time.Duration
 	FocusPeriod      int
+	// the config at the time of the backup. The current config wins over it on restore.
+	Config           *lib.Config
+	ChunkSeq         uint64
 	Diffs            *lib.CircularQueuePublic
 	TranslationCache map[string]string
+	Symbols          *lib.SymbolTable
+	DecayedBaseline  *lib.DecayedCounter
+	LongWindow       *lib.SlidingWindow
+	Windows          *lib.WindowHierarchy
+	Gaps             *lib.GapDetector
+	Bursts           *lib.BurstDetector
+	Trends           *lib.TrendTracker
 }
 
 var wordDiffQueue *lib.CircularQueue = lib.NewCircularQueue(FOCUS_PERIOD)
 var globalDiff *lib.WordDiff = lib.NewWordDiff()
-var longGlobalDiff *lib.WordDiff = lib.NewWordDiff()
+// these two, bursts, tweetSampler and tweetBuffer depend on the config, so loadConfig creates them again
+var longGlobalDiff lib.LongCounter = newLongCounter(lib.DefaultConfig())
+// nil when we use the cumulative longGlobalDiff as the baseline
+var baseline lib.Baseline = newBaseline(lib.DefaultConfig())
+// chunks roll up into minute and hour buckets, which the windows that /api/words/top?window= serves are built from
+var rollupWindows *lib.WindowHierarchy = lib.NewWindowHierarchy(
+	[]time.Duration{time.Minute, time.Hour},
+	[]lib.RollupWindow{
+		{Name: "1m", Level: 0, Span: 1},
+		{Name: "5m", Level: 0, Span: 5},
+		{Name: "1h", Level: 0, Span: 60},
+		{Name: "24h", Level: 1, Span: 24},
+	},
+)
+var gapDetector *lib.GapDetector = lib.NewGapDetector(gapMinRatio, gapAlpha, maxRecentGaps)
+// guards adding and removing chunks from wordDiffQueue, so the API can take a consistent look at them
+var wordDiffQueueMutex sync.Mutex
+var bursts *lib.BurstDetector = lib.NewBurstDetector(burstLevels, burstBase, burstGamma, FOCUS_PERIOD, burstMinCount, burstMaxWords, burstMinRate, maxRecentBursts)
+var trends *lib.TrendTracker = lib.NewTrendTracker(trendExitAfter, maxTrends)
+var coOccurrence *lib.CoOccurrence = lib.NewCoOccurrence()
+var relatedIndex *lib.RelatedIndex = lib.NewRelatedIndex(relatedMaxWords, relatedMaxNeighbors, relatedHalfLife, relatedMinRate)
+var tweetSampler *lib.TweetSampler = lib.NewTweetSampler(sampleSize, sampleMaxDistance, lib.DefaultConfig().SampleText)
+// nil when the search is turned off
+var tweetBuffer *lib.TweetBuffer = newTweetBuffer(lib.DefaultConfig())
+var displayForms *lib.DisplayForms = lib.NewDisplayForms(maxDisplayForms)
+var hashtagSegmenter *lib.HashtagSegmenter = lib.NewHashtagSegmenter(hashtagMinRate, hashtagMaxWordLength, hashtagMinSplitLength, hashtagMaxCached)
 var chunkUpdateChannel = make(chan int)
 var globalTweetCount int64
-var topCache []WordRankingPair = make([]WordRankingPair, 100)
+
+// the number of tweets in globalDiff. Updated with sync/atomic, since the API reads it.
+var focusTweetCount int64
+
+// the sequence number of the last chunk pushed to the wordDiffQueue. Updated with sync/atomic, since the API reads it.
+var chunkSeq uint64
+
+// tweets we received but could not count since the last chunk was sealed. Updated with sync/atomic.
+var droppedTweetCount int64
+// the ways /api/words/top can rank words, picked with ?scorer=. The Scorer setting picks the one used for the
+// cached top list. Rebuilt when the config is reloaded, so go through getScorer.
+var scorers map[string]lib.Scorer = newScorers(lib.DefaultConfig())
+var scorersMutex sync.RWMutex
+
+// the top words of the focus window with the default scorer, updated every second by getTopWorker.
+// a full walk every topIndexRebuildPasses passes catches the words whose scores drifted without being touched.
+const (
+	topIndexCapacity      int = 1000
+	topIndexRebuildPasses int = 60
+)
+
+var topIndex *lib.TopIndex = lib.NewTopIndex(topIndexCapacity)
+
+// the public top list is a smoothed version of the best boardSize words of topIndex, see the Board* settings.
+// ?raw=true on /api/words/top skips it.
+const boardSize int = 100
+
+var topBoard *lib.TopBoard = lib.NewTopBoard()
+
+func newLongCounter(c *lib.Config) lib.LongCounter {
+	if c.LongCounter == "approx" {
+		return lib.NewApproxCounter(approxEpsilon, approxDelta, approxTopK)
+	}
+
+	return lib.NewWordDiff()
+}
+
+func newTweetBuffer(c *lib.Config) *lib.TweetBuffer {
+	if c.SearchTweets == 0 {
+		return nil
+	}
+
+	return lib.NewTweetBuffer(c.SearchTweets)
+}
+
+func newScorers(c *lib.Config) map[string]lib.Scorer {
+	return map[string]lib.Scorer{
+		"multiple": &lib.MultipleScorer{
+			MinMultiple:      c.MinMultiple,
+			MaxMultiple:      c.MaxMultiple,
+			MinCount:         c.MinCount,
+			MaxAdjustedCount: c.MaxAdjustedCount,
+			ThresholdTweets:  c.ThresholdTweets,
+		},
+		"zscore": lib.NewZScoreScorer(zScoreSignificance, zScoreExactBelow, zScoreMinChunks),
+	}
+}
+
+// getScorer returns the named scorer, or the configured default if name is empty.
+func getScorer(name string) (lib.Scorer, bool) {
+	if name == "" {
+		name = config().Scorer
+	}
+	scorersMutex.RLock()
+	defer scorersMutex.RUnlock()
+
+	scorer, ok := scorers[name]
+	return scorer, ok
+}
+
+func scorerNames() []string {
+	scorersMutex.RLock()
+	defer scorersMutex.RUnlock()
+
+	names := make([]string, 0, len(scorers))
+	for name := range scorers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func config() *lib.Config {
+	c, ok := configValue.Load().(*lib.Config)
+	if !ok {
+		return lib.DefaultConfig()
+	}
+
+	return c
+}
+
+func validateConfig(c *lib.Config) error {
+	if _, ok := newScorers(c)[c.Scorer]; !ok {
+		return fmt.Errorf("unknown scorer %q", c.Scorer)
+	}
+
+	return nil
+}
+
+// loadConfig parses the flags and loads the config. It has to run before the workers start.
+func loadConfig() {
+	configLoader.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	c, err := configLoader.Load()
+	if err == nil {
+		err = validateConfig(c)
+	}
+	if err != nil {
+		log.Fatal("Invalid config: ", err)
+	}
+	applyConfig(c)
+	CHUNK_PERIOD = c.ChunkPeriod
+	FOCUS_PERIOD = c.FocusPeriod
+	wordDiffQueue = lib.NewCircularQueue(FOCUS_PERIOD)
+	longGlobalDiff = newLongCounter(c)
+	baseline = newBaseline(c)
+	tweetSampler = lib.NewTweetSampler(sampleSize, sampleMaxDistance, c.SampleText)
+	bursts = lib.NewBurstDetector(burstLevels, burstBase, burstGamma, FOCUS_PERIOD, burstMinCount, burstMaxWords, burstMinRate, maxRecentBursts)
+	tweetBuffer = newTweetBuffer(c)
+
+	go reloadConfigOnSignal()
+}
+
+func applyConfig(c *lib.Config) {
+	scorersMutex.Lock()
+	scorers = newScorers(c)
+	scorersMutex.Unlock()
+	configValue.Store(c)
+	atomic.StoreInt32(&topIndexStale, 1)
+}
+
+func reloadConfigOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		reloadConfig()
+	}
+}
+
+// reloadConfig loads the config again. If it is invalid the old one stays. Settings that need a restart keep their
+// old values, and a new focus period is handed over to the processTweets goroutine.
+func reloadConfig() {
+	c, err := configLoader.Load()
+	if err == nil {
+		err = validateConfig(c)
+	}
+	if err != nil {
+		log.Println("Invalid config, keeping the old one:", err)
+		return
+	}
+
+	old := config()
+	if cold := old.ColdChanges(c); len(cold) > 0 {
+		log.Printf("%s can only change with a restart, keeping the old value.\n", strings.Join(cold, ", "))
+		c.KeepCold(old)
+	}
+	for _, change := range old.Changes(c) {
+		log.Println("Config changed:", change)
+	}
+	if c.FocusPeriod != old.FocusPeriod {
+		atomic.StoreInt64(&pendingFocusPeriod, int64(c.FocusPeriod))
+	}
+	applyConfig(c)
+}
+
+// evictOldestChunkUnlocked drops the oldest chunk from the focus window. The caller must hold wordDiffQueueMutex.
+func evictOldestChunkUnlocked() {
+	obj := wordDiffQueue.Dequeue()
+	oldestChunk, ok := obj.(*lib.Chunk)
+	if !ok {
+		log.Printf("%T %v", obj, obj)
+		log.Println(wordDiffQueue.String())
+		log.Panic("Could not convert dequeued object to Chunk.")
+	}
+	oldestChunk.Words.SubFrom(globalDiff)
+	topIndex.TouchChunk(oldestChunk.Words)
+	coOccurrence.SubChunk(oldestChunk)
+	displayForms.SubChunk(oldestChunk)
+	if tweetBuffer != nil {
+		tweetBuffer.Expire(oldestChunk.Seq)
+	}
+	atomic.AddInt64(&focusTweetCount, -oldestChunk.Tweets)
+	// the chunk is gone, so its words no longer need to be in the symbol table once nobody is reading them
+	oldestChunk.Evict()
+}
+
+// resizeFocusWindowUnlocked changes the number of chunks in the focus window, dropping the oldest ones if it shrinks.
+// The caller must hold wordDiffQueueMutex.
+func resizeFocusWindowUnlocked(focusPeriod int) {
+	// a CircularQueue of n holds n-1 elements
+	for wordDiffQueue.Len() > focusPeriod-1 {
+		evictOldestChunkUnlocked()
+	}
+	resized := lib.NewCircularQueue(focusPeriod)
+	wordDiffQueue.Walk(func(obj interface{}) {
+		resized.Enqueue(obj)
+	})
+	wordDiffQueue = resized
+	FOCUS_PERIOD = focusPeriod
+	bursts.SetWindow(focusPeriod)
+}
+
+// returns nil for the cumulative baseline (longGlobalDiff)
+func newBaseline(c *lib.Config) lib.Baseline {
+	switch c.Baseline {
+	case "decayed":
+		return lib.NewDecayedCounter(c.HalfLife)
+	case "window":
+		return lib.NewSlidingWindow(longBucketPeriod, c.LongWindow)
+	}
+
+	return nil
+}
+
+// the counter behind the [long] period of the API
+func longPeriodCounter() lib.LongCounter {
+	if window, ok := baseline.(*lib.SlidingWindow); ok {
+		return window.Total
+	}
+
+	return longGlobalDiff
+}
 
 func createBackup() {
 	log.Println("Starting backup")
@@ -112,14 +491,46 @@ func createBackup() {
 	// not locking every single one of them
 	d := &RecoveryPoint{
 		GlobalTweetCount: globalTweetCount,
-		LongDiff:         longGlobalDiff,
 		FocusDiff:        globalDiff,
-		AggSize:          AGG_SIZE,
+		FocusTweetCount:  atomic.LoadInt64(&focusTweetCount),
+		ChunkPeriod:      CHUNK_PERIOD,
 		FocusPeriod:      FOCUS_PERIOD,
+		Config:           config(),
+		ChunkSeq:         atomic.LoadUint64(&chunkSeq),
 		Diffs:            wordDiffQueue.Public(),
 		TranslationCache: translateCache,
+		// chunks that readers still hold release their words from other goroutines, so encode a copy
+		Symbols:          lib.Symbols.Snapshot(),
+		Windows:          rollupWindows,
+		Gaps:             gapDetector,
+		Bursts:           bursts,
+		Trends:           trends,
 	}
-	gob.Register((wordDiffQueue.Last()).(lib.WordDiff))
+	trends.Lock()
+	defer trends.Unlock()
+	bursts.Lock()
+	defer bursts.Unlock()
+	gapDetector.Lock()
+	defer gapDetector.Unlock()
+	rollupWindows.Lock()
+	defer rollupWindows.Unlock()
+	switch b := baseline.(type) {
+	case *lib.DecayedCounter:
+		d.DecayedBaseline = b
+	case *lib.SlidingWindow:
+		d.LongWindow = b
+	}
+	if baseline != nil {
+		baseline.Lock()
+		defer baseline.Unlock()
+	}
+	switch long := longGlobalDiff.(type) {
+	case *lib.WordDiff:
+		d.LongDiff = long
+	case *lib.ApproxCounter:
+		d.LongApprox = long
+	}
+	gob.Register(&lib.Chunk{})
 
 	buffer := bytes.NewBuffer([]byte{})
 	encoder := gob.NewEncoder(buffer)
@@ -147,8 +558,12 @@ func restoreFromBackup() {
 		return
 	}
 
+	// older backups stored the chunks as plain WordDiffs, IdDiffs or SealedChunks
 	dummy := lib.NewWordDiff()
 	gob.Register(*dummy)
+	gob.Register(lib.NewIdDiff())
+	gob.Register(&lib.SealedChunk{})
+	gob.Register(&lib.Chunk{})
 	decoder := gob.NewDecoder(file)
 	recovery := &RecoveryPoint{}
 	err = decoder.Decode(&recovery)
@@ -157,20 +572,164 @@ func restoreFromBackup() {
 	}
 
 	globalTweetCount = recovery.GlobalTweetCount
-	longGlobalDiff = recovery.LongDiff
+	approx := config().LongCounter == "approx"
+	if approx && recovery.LongApprox != nil {
+		longGlobalDiff = recovery.LongApprox
+	} else if !approx && recovery.LongDiff != nil {
+		longGlobalDiff = recovery.LongDiff
+	} else if recovery.LongApprox == nil && recovery.LongDiff == nil {
+		// checked here, since a nil *lib.WordDiff in oldLong below would not be a nil LongCounter
+		log.Println("The backup has no long counts, they start over.")
+	} else {
+		// the backup was made with the other kind of counter, so carry over what it has
+		log.Println("Converting the long counts in the backup to the configured counter...")
+		var oldLong lib.LongCounter = recovery.LongDiff
+		if recovery.LongApprox != nil {
+			oldLong = recovery.LongApprox
+		}
+		switch long := longGlobalDiff.(type) {
+		case *lib.WordDiff:
+			oldLong.Walk(func(word string, count int) {
+				long.Words[word] = count
+			})
+		case *lib.ApproxCounter:
+			// longGlobalDiff is already locked, so don't use AddWord
+			oldLong.Walk(func(word string, count int) {
+				long.Sketch.Add(word, uint32(count))
+				long.Top.Add(word, count)
+			})
+		}
+	}
 	globalDiff = recovery.FocusDiff
-	AGG_SIZE = recovery.AggSize
-	FOCUS_PERIOD = recovery.FocusPeriod
+	// the current config wins over the backup's
+	if recovery.Config != nil {
+		for _, change := range recovery.Config.Changes(config()) {
+			log.Println("Config changed since the backup:", change)
+		}
+	}
+	if recovery.ChunkPeriod != 0 && recovery.ChunkPeriod != CHUNK_PERIOD {
+		log.Printf("The backup has %v chunks, the focus window will mix them with %v chunks until they age out.\n", recovery.ChunkPeriod, CHUNK_PERIOD)
+	}
+	atomic.StoreUint64(&chunkSeq, recovery.ChunkSeq)
 	translateCache = recovery.TranslationCache
 	if translateCache == nil {
 		translateCache = make(map[string]string)
 	}
-	wordDiffQueue.SetQueue(recovery.Diffs)
+	if recovery.Symbols != nil {
+		lib.Symbols = recovery.Symbols
+	}
+	if recovery.Gaps != nil {
+		gapDetector = recovery.Gaps
+	}
+	if recovery.Bursts != nil {
+		// the current settings win over the ones in the backup
+		recovery.Bursts.Gamma = burstGamma
+		recovery.Bursts.MaxWords = burstMaxWords
+		recovery.Bursts.SetWindow(FOCUS_PERIOD)
+		bursts = recovery.Bursts
+	}
+	if recovery.Trends != nil {
+		trends = recovery.Trends
+	}
+	if recovery.Windows != nil {
+		// the configured windows win over the ones in the backup
+		rollupWindows.Restore(recovery.Windows)
+	}
+	switch b := baseline.(type) {
+	case *lib.DecayedCounter:
+		if recovery.DecayedBaseline != nil {
+			// the configured half-life wins over the one in the backup
+			recovery.DecayedBaseline.HalfLife = b.HalfLife
+			baseline = recovery.DecayedBaseline
+		} else {
+			// start from the cumulative rates, they will decay towards the recent ones from here
+			b.AddTweet(time.Now())
+			b.Tweets.Value = float64(globalTweetCount)
+			// we may still be holding the lock on longGlobalDiff from the top of this function
+			longGlobalDiff.WalkUnlocked(func(word string, count int) {
+				b.AddWord(word, float64(count))
+			})
+		}
+	case *lib.SlidingWindow:
+		// there is no way to split the cumulative counts into buckets, so without a window in the backup it starts empty
+		if recovery.LongWindow != nil {
+			// the configured length wins over the one in the backup
+			if recovery.LongWindow.Length != b.Length {
+				log.Printf("Changing the %v long window from the backup to the configured %v.\n", recovery.LongWindow.Length, b.Length)
+				recovery.LongWindow.SetLength(b.Length)
+			}
+			baseline = recovery.LongWindow
+		}
+	}
+	if _, ok := baseline.(*lib.SlidingWindow); !ok && recovery.LongWindow != nil {
+		log.Println("The baseline is no longer the long window, dropping the one in the backup.")
+		recovery.LongWindow.Release()
+	}
+	restoredQueue := lib.NewCircularQueue(recovery.Diffs.Capacity)
+	restoredQueue.SetQueue(recovery.Diffs)
+	wordDiffQueue = restoredQueue
+
+	// convert chunks from backups that predate the symbol table, sealed chunks or time based chunks.
+	// those all had AggSize tweets, and we don't know when they were made.
+	queue := wordDiffQueue.Public()
+	aggSize := int64(recovery.AggSize)
+	// go from the oldest to the newest chunk so the sequence numbers are in order
+	for k := 0; k < wordDiffQueue.Len(); k++ {
+		i := (queue.Head + k) % queue.Capacity
+		var chunk *lib.Chunk
+		switch oldDiff := queue.Data[i].(type) {
+		case lib.WordDiff:
+			diff := lib.NewIdDiff()
+			for word, count := range oldDiff.Words {
+				diff.AddWord(word, count)
+			}
+			chunk = &lib.Chunk{Tweets: aggSize, Words: diff.Seal()}
+		case *lib.IdDiff:
+			chunk = &lib.Chunk{Tweets: aggSize, Words: oldDiff.Seal()}
+		case *lib.SealedChunk:
+			chunk = &lib.Chunk{Tweets: aggSize, Words: oldDiff}
+		default:
+			continue
+		}
+		chunk.Seq = atomic.AddUint64(&chunkSeq, 1)
+		queue.Data[i] = chunk
+	}
+	// the saved count includes the tweets of the open chunk, which is not in the backup and would never be
+	// subtracted again, so count the tweets of the restored chunks instead
+	var focusTweets int64
+	wordDiffQueue.Walk(func(obj interface{}) {
+		if chunk, ok := obj.(*lib.Chunk); ok {
+			focusTweets += chunk.Tweets
+			coOccurrence.AddChunk(chunk)
+			displayForms.AddChunk(chunk)
+		}
+	})
+	atomic.StoreInt64(&focusTweetCount, focusTweets)
+	if recovery.Diffs.Capacity != FOCUS_PERIOD {
+		log.Printf("Resizing the focus window from the backup's %d chunks to %d.\n", recovery.Diffs.Capacity, FOCUS_PERIOD)
+		resizeFocusWindowUnlocked(FOCUS_PERIOD)
+	}
+
+	// the symbol counts in the backup include references from things that are not in it, e.g. the open chunk
+	// and evicted chunks that were still being read, so count them again from what was restored
+	lib.Symbols.RebuildRefs(func(retain func(uint32)) {
+		wordDiffQueue.Walk(func(obj interface{}) {
+			if chunk, ok := obj.(*lib.Chunk); ok && chunk.Words != nil {
+				chunk.Words.Walk(func(id uint32, count int) {
+					retain(id)
+				})
+			}
+		})
+		if window, ok := baseline.(*lib.SlidingWindow); ok {
+			window.WalkIds(retain)
+		}
+		rollupWindows.WalkIds(retain)
+	})
 }
 
 func streamTweets(tweets chan<- StreamDataSchema) {
 	client := &http.Client{}
-	req, _ := http.NewRequest("GET", "https://api.twitter.com/2/tweets/sample/stream", nil)
+	req, _ := http.NewRequest("GET", "https://api.twitter.com/2/tweets/sample/stream?tweet.fields=created_at,author_id", nil)
 	req.Header.Set("Authorization", "Bearer "+os.Getenv("TWITTER_BEARER"))
 	resp, err := client.Do(req)
 
@@ -212,6 +771,7 @@ func streamTweets(tweets chan<- StreamDataSchema) {
 		if err := json.Unmarshal(line, &data); err != nil {
 			log.Println("failed to unmarshal bytes:", err)
 			log.Println(string(line))
+			atomic.AddInt64(&droppedTweetCount, 1)
 			// try to read again. Usually it is because the twitter API had nothing to give.
 			continue
 		}
@@ -235,67 +795,260 @@ func isValidWord(word string) bool {
 	return true
 }
 
+// the time a tweet is counted at: when it was created in event time mode, otherwise when it arrived
+func tweetTime(tweet StreamDataSchema) time.Time {
+	if config().Time == "event" && !tweet.Data.CreatedAt.IsZero() {
+		return tweet.Data.CreatedAt
+	}
+
+	return time.Now()
+}
+
 func processTweets(tweets <-chan StreamDataSchema) {
 	urlRule := regexp.MustCompile(`((([A-Za-z]{3,9}:(?:\/\/)?)(?:[-;:&=\+\$,\w]+@)?[A-Za-z0-9.-]+|(?:www.|[-;:&=\+\$,\w]+@)[A-Za-z0-9.-]+)((?:\/[\+~%\/.\w-_]*)?\??(?:[-\+=&;%@.\w_]*)#?(?:[\w]*))?)`)
 	delimRule := regexp.MustCompile(` |"|\.|\,|\!|\?|\:|、|\n`)
 
-	diff := lib.NewWordDiff()
-	for tweet := range tweets {
+	countTweet := func(tweet StreamDataSchema, at time.Time, chunk *lib.Chunk, diff *lib.IdDiff) {
 		globalTweetCount++
+		atomic.AddInt64(&focusTweetCount, 1)
+		if baseline != nil {
+			baseline.AddTweet(at)
+		}
 		// this is inefficient. If our process is slowing down, make this is a custom parser.
 		sanatizedText := urlRule.ReplaceAllString(tweet.Data.Text, "")
 		tokens := delimRule.Split(sanatizedText, -1)
+		words := make([]string, 0, len(tokens))
+		// how each word was written
+		forms := make([]string, 0, len(tokens))
+		var hashtags []string
 		for _, token := range tokens {
 			word := sanatizeWord(token)
 			validWord := isValidWord(word)
 			if validWord {
-				globalDiff.IncWord(word)
-				longGlobalDiff.IncWord(word)
-				diff.IncWord(word)
+				words = append(words, word)
+				forms = append(forms, token)
+				if word[0] == '#' {
+					hashtags = append(hashtags, token)
+				}
+			}
+		}
+		// hashtags are also counted as the words they are made of, unless the tweet already has those words,
+		// so e.g. #BlackFriday adds to black and friday. Only the counts get them; the tweet didn't really use them,
+		// so they don't go into pairs or samples.
+		var segments []string
+		if len(hashtags) > 0 {
+			used := make(map[string]bool, len(words))
+			for _, word := range words {
+				used[word] = true
+			}
+			// only lock the rates for each lookup, uncached hashtags take a while to split
+			rate := func(word string) float64 {
+				lockLongRate()
+				defer unlockLongRate()
+				return longRateUnlocked(word)
+			}
+			for _, hashtag := range hashtags {
+				for _, segment := range hashtagSegmenter.Segment(hashtag, rate) {
+					word := sanatizeWord(segment)
+					if isValidWord(word) && !used[word] {
+						used[word] = true
+						segments = append(segments, word)
+					}
+				}
+			}
+		}
+		counted := words
+		if len(segments) > 0 {
+			counted = append(append(make([]string, 0, len(words)+len(segments)), words...), segments...)
+		}
+		for _, word := range counted {
+			globalDiff.IncWord(word)
+			longGlobalDiff.IncWord(word)
+			if baseline != nil {
+				baseline.IncWord(word)
 			}
+			diff.IncWord(word)
+		}
+		coOccurrence.CountTweet(words, chunk)
+		displayForms.CountTweet(words, forms, chunk)
+		seq := atomic.LoadUint64(&chunkSeq)
+		relatedIndex.CountTweet(words, seq, relatedMaxTweetWords)
+		tweetSampler.Offer(words, tweet.Data.ID, tweet.Data.AuthorID, tweet.Data.Text, tweet.Data.CreatedAt, at, focusWindowStart())
+		// the tweet goes into the next chunk that is pushed
+		if tweetBuffer != nil {
+			tweetBuffer.Add(tweet.Data.ID, tweet.Data.AuthorID, tweet.Data.CreatedAt, tweet.Data.Text, seq+1)
 		}
 
-		if globalTweetCount%int64(focusPrunePeriod) == 0 {
+		c := config()
+		if globalTweetCount%int64(c.FocusPrunePeriod) == 0 {
 			globalDiff.Prune(0)
 		}
-		if globalTweetCount%int64(longPrunePeriod) == 0 {
+		if globalTweetCount%int64(c.LongPrunePeriod) == 0 {
 			longGlobalDiff.Prune(1)
+			if decayed, ok := baseline.(*lib.DecayedCounter); ok {
+				decayed.Prune(decayedPruneMin)
+			}
+			// split hashtags again with the new rates
+			hashtagSegmenter.Reset()
 
 			// right after pruning, store the backup
 			createBackup()
 		}
+	}
 
-		if globalTweetCount%int64(AGG_SIZE) == 0 {
-			if wordDiffQueue.IsFull() {
-				obj := wordDiffQueue.Dequeue()
-				oldestDiff, ok := obj.(lib.WordDiff)
-				if !ok {
-					log.Printf("%T %v", obj, obj)
-					log.Println(wordDiffQueue.String())
-					log.Panic("Could not convert dequeued object to WordDiff.")
-				}
-				globalDiff.Sub(&oldestDiff)
-			}
+	pushChunk := func(chunk *lib.Chunk, diff *lib.IdDiff, start time.Time) {
+		wordDiffQueueMutex.Lock()
+		if focusPeriod := atomic.SwapInt64(&pendingFocusPeriod, 0); focusPeriod != 0 {
+			log.Printf("Resizing the focus window from %d to %d chunks.\n", FOCUS_PERIOD, focusPeriod)
+			resizeFocusWindowUnlocked(int(focusPeriod))
+		}
+		if wordDiffQueue.IsFull() {
+			evictOldestChunkUnlocked()
+		}
+
+		// chunks are never written to again once they are in the queue, so freeze them
+		chunk.Seq = atomic.AddUint64(&chunkSeq, 1)
+		chunk.Start = start
+		chunk.IngestedAt = time.Now()
+		chunk.Gap = gapDetector.Observe(start, start.Add(CHUNK_PERIOD), chunk.Tweets)
+		if chunk.Gap {
+			log.Printf("Chunk %d only has %d tweets, the stream might be down.\n", chunk.Seq, chunk.Tweets)
+		}
+		chunk.Dropped += atomic.SwapInt64(&droppedTweetCount, 0)
+		chunk.Words = diff.Seal()
+		topIndex.TouchChunk(chunk.Words)
+		wordDiffQueue.Enqueue(chunk)
+		wordDiffQueueMutex.Unlock()
+		rollupWindows.AddChunk(start, chunk.Tweets, chunk.Words)
 
-			wordDiffQueue.Enqueue(*diff)
-			diff = lib.NewWordDiff()
+		lockLongRate()
+		bursts.AddChunk(start, start.Add(CHUNK_PERIOD), chunk.Tweets, chunk.Words, longRateUnlocked)
+		unlockLongRate()
 
-			// update the chunkUpdate channel
-			select {
-			case chunkUpdateChannel <- 0:
-			default:
-				// message was not recieved, carry on.
+		// update the chunkUpdate channel
+		select {
+		case chunkUpdateChannel <- 0:
+		default:
+			// message was not recieved, carry on.
+		}
+	}
+
+	// Setting time to event assigns tweets to chunks by their created_at instead of when they arrive.
+	// Chunks are sealed once tweets created allowedLateness after their end come in; tweets arriving later
+	// than that are dropped. Open chunks are not part of the backups, so a restart loses at most that much.
+	// Tweets created more than allowedLateness in the future are dropped too, since one of them would move the
+	// watermark past every real tweet.
+	if config().Time == "event" {
+		chunker := lib.NewEventTimeChunker(CHUNK_PERIOD, config().AllowedLateness, FOCUS_PERIOD)
+		for tweet := range tweets {
+			at := tweetTime(tweet)
+			if at.After(time.Now().Add(chunker.AllowedLateness)) {
+				atomic.AddInt64(&droppedTweetCount, 1)
+				continue
+			}
+			open := chunker.Assign(at)
+			if open != nil {
+				countTweet(tweet, at, open.Chunk, open.Words)
+			}
+			for _, sealed := range chunker.Advance(at) {
+				pushChunk(sealed.Chunk, sealed.Words, sealed.Start)
 			}
 		}
+		return
+	}
+
+	diff := lib.NewIdDiff()
+	// everything about the current chunk but its words
+	chunk := &lib.Chunk{}
+	chunkStart := time.Now().Truncate(CHUNK_PERIOD)
+	// the chunk is sealed at the end of its period even if no tweets are coming in
+	sealTimer := time.NewTimer(time.Until(chunkStart.Add(CHUNK_PERIOD)))
+	for {
+		select {
+		case tweet := <-tweets:
+			chunk.Tweets++
+			chunk.AddCreatedAt(tweet.Data.CreatedAt)
+			countTweet(tweet, tweetTime(tweet), chunk, diff)
+
+		case <-sealTimer.C:
+			pushChunk(chunk, diff, chunkStart)
+
+			chunk = &lib.Chunk{}
+			chunkStart = time.Now().Truncate(CHUNK_PERIOD)
+			sealTimer.Reset(time.Until(chunkStart.Add(CHUNK_PERIOD)))
+		}
+	}
+}
+
+// the start of the oldest chunk in the focus window
+func focusWindowStart() time.Time {
+	wordDiffQueueMutex.Lock()
+	defer wordDiffQueueMutex.Unlock()
+
+	chunk, ok := wordDiffQueue.First().(*lib.Chunk)
+	if !ok {
+		return time.Now()
+	}
+
+	return chunk.Start
+}
+
+// retainFocusChunks returns the chunks currently in the focus window, oldest first.
+// They stay usable even if they are evicted meanwhile, until they are passed to releaseChunks.
+func retainFocusChunks() []*lib.Chunk {
+	wordDiffQueueMutex.Lock()
+	defer wordDiffQueueMutex.Unlock()
+
+	chunks := make([]*lib.Chunk, 0, wordDiffQueue.Len())
+	wordDiffQueue.Walk(func(obj interface{}) {
+		if chunk, ok := obj.(*lib.Chunk); ok {
+			chunk.Retain()
+			chunks = append(chunks, chunk)
+		}
+	})
+
+	return chunks
+}
+
+func releaseChunks(chunks []*lib.Chunk) {
+	for _, chunk := range chunks {
+		chunk.Release()
 	}
 }
 
+// getMomentum returns the topAmount words whose rate is rising (or falling) fastest over spans of span chunks.
+func getMomentum(span int, rising bool, topAmount int) []lib.WordMomentum {
+	chunks := retainFocusChunks()
+	momentum := lib.Momentum(chunks, span, momentumMinCount)
+	releaseChunks(chunks)
+	if rising {
+		sort.Slice(momentum, func(i, j int) bool { return momentum[i].Score > momentum[j].Score })
+	} else {
+		sort.Slice(momentum, func(i, j int) bool { return momentum[i].Score < momentum[j].Score })
+	}
+
+	for i, word := range momentum {
+		if (rising && word.Score <= 0) || (!rising && word.Score >= 0) {
+			momentum = momentum[:i]
+			break
+		}
+	}
+	if len(momentum) > topAmount {
+		momentum = momentum[:topAmount]
+	}
+
+	return momentum
+}
+
 func getTopWorker() {
 	var targetPeriod int64 = 1000
-	for {
+	for pass := 0; ; pass++ {
 		t1 := time.Now().UnixMilli()
 
-		topCache = getTop(100)
+		stale := atomic.SwapInt32(&topIndexStale, 0) == 1
+		updateTopIndex(stale || pass%topIndexRebuildPasses == 0)
+		updateBoard()
+		observeTrends()
 
 		t2 := time.Now().UnixMilli()
 		// log.Printf("getTop(): %dms\n", (t2 - t1))
@@ -322,93 +1075,310 @@ func tweetsWorker() {
 	}
 }
 
-func min(a, b float32) float32 {
-	if a < b {
-		return a
+// updateTopIndex rescores the words touched since the last pass, or every word if full is set.
+func updateTopIndex(full bool) {
+	scorer, _ := getScorer("")
+	focusTweets := atomic.LoadInt64(&focusTweetCount)
+	// wait until the long term counts cover at least one window
+	if focusTweets == 0 || globalTweetCount < focusTweets {
+		topIndex.Reset()
+		topIndex.Refresh()
+		return
+	}
+
+	var words []string
+	if !full {
+		words = topIndex.TakeTouched()
+	}
+	chunks := retainFocusChunks()
+	defer releaseChunks(chunks)
+
+	entries := make(map[string]*lib.TopIndexEntry)
+	scoreWord := func(word string, count int) {
+		// XXX: Special testing rule to get rid of hashtags
+		if count == 0 || word[0] == '#' {
+			entries[word] = nil
+			return
+		}
+
+		input := lib.ScoreInput{Word: word, Count: count, WindowTweets: focusTweets, LongRate: longRateUnlocked(word), Chunks: chunks}
+		score, ok := scorer.Score(input)
+		if !ok {
+			entries[word] = nil
+			return
+		}
+		input.Chunks = nil
+		entries[word] = &lib.TopIndexEntry{Input: input, Score: score}
+	}
+
+	// only hold the locks for the scoring, so the heap work doesn't hold up ingestion
+	globalDiff.Lock()
+	lockLongRate()
+	if full {
+		globalDiff.WalkUnlocked(scoreWord)
+	} else {
+		for _, word := range words {
+			scoreWord(word, globalDiff.GetUnlocked(word))
+		}
+	}
+	unlockLongRate()
+	globalDiff.Unlock()
+
+	if full {
+		topIndex.Reset()
+	}
+	for word, entry := range entries {
+		topIndex.Set(word, entry)
+	}
+	topIndex.Refresh()
+
+	// the words that can end up in a story
+	top := topIndex.Top(topIndexCapacity)
+	candidates := make([]string, len(top))
+	for i, entry := range top {
+		candidates[i] = entry.Input.Word
+	}
+	coOccurrence.SetCandidates(candidates)
+	tweetSampler.SetCandidates(candidates)
+	tweetSampler.Prune(focusWindowStart())
+}
+
+// updateBoard shows the current top list to the public board.
+func updateBoard() {
+	c := config()
+	// twice the board, so the words just below the cut-off are tracked too
+	top := getTop(2 * boardSize)
+	raw := make([]lib.BoardInput, len(top))
+	for i, pair := range top {
+		// top is lowest score first
+		raw[len(top)-1-i] = lib.BoardInput{Word: pair.Word, Score: pair.WordScore, Data: pair}
+	}
+	topBoard.Update(time.Now(), raw, lib.BoardSettings{
+		Size:       boardSize,
+		MinDwell:   c.BoardMinDwell,
+		Alpha:      c.BoardAlpha,
+		EnterRatio: c.BoardEnterRatio,
+		ExitRatio:  c.BoardExitRatio,
+	})
+}
+
+// getBoard returns the best topAmount words of the public board, lowest score first like getTop.
+// Their scores are the smoothed ones.
+func getBoard(topAmount int) []WordRankingPair {
+	entries := topBoard.Top(topAmount)
+	board := make([]WordRankingPair, len(entries))
+	for i, entry := range entries {
+		pair := entry.Data.(WordRankingPair)
+		pair.WordScore = entry.Score
+		board[len(board)-1-i] = pair
+	}
+
+	return board
+}
+
+// observeTrends shows the current top list to the trend tracker.
+func observeTrends() {
+	top := getTop(trendTopSize)
+	words := make([]lib.TrendWord, len(top))
+	for i, pair := range top {
+		// top is lowest score first
+		words[i] = lib.TrendWord{Word: pair.Word, Score: pair.WordScore, Rank: len(top) - i}
+	}
+	trends.Observe(time.Now(), words)
+}
+
+// getSeries returns the count of word in every chunk of the focus window, or every bucket of one of the rollup
+// windows, and how long the chunks or buckets are.
+func getSeries(window string, word string) ([]lib.SeriesPoint, time.Duration, bool) {
+	if window == "focus" {
+		chunks := retainFocusChunks()
+		defer releaseChunks(chunks)
+		return lib.ChunkSeries(chunks, word), CHUNK_PERIOD, true
+	}
+
+	return rollupWindows.Series(window, word)
+}
+
+// addSparklines sets the sparkline of every word: its count over the focus window, in points buckets.
+func addSparklines(words []WordRankingPair, points int) {
+	chunks := retainFocusChunks()
+	defer releaseChunks(chunks)
+	for i := range words {
+		words[i].Sparkline = lib.Sparkline(lib.ChunkSeries(chunks, words[i].Word), points)
 	}
-	return b
 }
 
+// getDisplay returns how word is most often written in the focus window.
+func getDisplay(word string) string {
+	return displayForms.Display(word, globalDiff.Get(word))
+}
+
+// getSamples returns up to n example tweets for word from the focus window, newest first.
+func getSamples(word string, n int) []lib.TweetSample {
+	return tweetSampler.Samples(word, n, focusWindowStart())
+}
+
+// getRelated returns the topAmount words most related to word, by lift or by how many tweets used both,
+// and about how many recent tweets used word.
+func getRelated(word string, topAmount int, byCount bool) ([]lib.RelatedWord, float64, bool) {
+	related, tweets, found := relatedIndex.Related(word, atomic.LoadUint64(&chunkSeq), relatedMinCount, func(neighbor string) float64 {
+		lockLongRate()
+		defer unlockLongRate()
+		return longRateUnlocked(neighbor)
+	})
+	if byCount {
+		sort.Slice(related, func(i, j int) bool { return related[i].Count > related[j].Count })
+	}
+	if len(related) > topAmount {
+		related = related[:topAmount]
+	}
+
+	return related, tweets, found
+}
+
+// getStories clusters the topAmount best words of the focus window into stories.
+func getStories(topAmount int) []lib.Story {
+	top := getTop(topAmount)
+	words := make([]lib.StoryWord, len(top))
+	globalDiff.Lock()
+	for i, pair := range top {
+		words[i] = lib.StoryWord{Word: pair.Word, Count: globalDiff.GetUnlocked(pair.Word), Score: pair.WordScore}
+	}
+	globalDiff.Unlock()
+
+	return coOccurrence.Stories(words, storyMinPairs, storyMinOverlap)
+}
+
+// getTop returns the top words of the focus window with the default scorer, lowest score first.
+// Anything the index covers is served from it without touching the counts.
 func getTop(topAmount int) []WordRankingPair {
-	// transformation multiple to make a long term count into a focus period count
-	adjustmentRatio := globalTweetCount / int64(FOCUS_PERIOD*AGG_SIZE)
-	top := make([]WordRankingPair, topAmount)
-	if adjustmentRatio == 0 {
+	if topAmount > topIndexCapacity {
+		scorer, _ := getScorer("")
+		return getTopFor(globalDiff, atomic.LoadInt64(&focusTweetCount), topAmount, scorer)
+	}
+
+	entries := topIndex.Top(topAmount)
+	top := make([]WordRankingPair, len(entries))
+	for i, entry := range entries {
+		top[len(top)-1-i] = WordRankingPair{
+			Word:      entry.Input.Word,
+			Count:     entry.Score.AdjustedCount,
+			Multiple:  entry.Score.Multiple,
+			WordScore: entry.Score.Score,
+		}
+	}
+
+	return top
+}
+
+// addExplanations sets the explanation of every word ranked by scorer in a window over focusDiff that covers
+// focusTweets tweets. The counts may have moved on since the words were ranked, which is fine for an explanation.
+func addExplanations(words []WordRankingPair, focusDiff *lib.WordDiff, focusTweets int64, scorer lib.Scorer) {
+	var chunks []*lib.Chunk
+	if focusDiff == globalDiff {
+		chunks = retainFocusChunks()
+		defer releaseChunks(chunks)
+	}
+
+	focusDiff.Lock()
+	defer focusDiff.Unlock()
+	lockLongRate()
+	defer unlockLongRate()
+
+	for i, pair := range words {
+		input := lib.ScoreInput{
+			Word:         pair.Word,
+			Count:        focusDiff.GetUnlocked(pair.Word),
+			WindowTweets: focusTweets,
+			LongRate:     longRateUnlocked(pair.Word),
+			Chunks:       chunks,
+		}
+		words[i].Explanation = scorer.Explain(input, lib.WordScore{
+			Score:         pair.WordScore,
+			AdjustedCount: pair.Count,
+			Multiple:      pair.Multiple,
+		})
+	}
+}
+
+// lockLongRate locks whatever longRateUnlocked reads from.
+func lockLongRate() {
+	if baseline != nil {
+		baseline.Lock()
+	} else {
+		longGlobalDiff.Lock()
+	}
+}
+
+func unlockLongRate() {
+	if baseline != nil {
+		baseline.Unlock()
+	} else {
+		longGlobalDiff.Unlock()
+	}
+}
+
+// how many times the word is normally used per tweet. The caller must hold lockLongRate.
+func longRateUnlocked(word string) float64 {
+	if baseline != nil {
+		return baseline.RateUnlocked(word)
+	}
+	if globalTweetCount == 0 {
+		return 0
+	}
+
+	return float64(longGlobalDiff.GetUnlocked(word)) / float64(globalTweetCount)
+}
+
+// getTopFor ranks the words of any window that covers focusTweets tweets, e.g. the focus window or a rollup window.
+func getTopFor(focusDiff *lib.WordDiff, focusTweets int64, topAmount int, scorer lib.Scorer) []WordRankingPair {
+	// wait until the long term counts cover at least one window
+	if focusTweets == 0 || globalTweetCount < focusTweets {
 		return make([]WordRankingPair, 0)
 	}
+	top := make([]WordRankingPair, topAmount)
+	var chunks []*lib.Chunk
+	if focusDiff == globalDiff {
+		chunks = retainFocusChunks()
+		defer releaseChunks(chunks)
+	}
 
 	foundNonZero := false
 
-	globalDiff.Lock()
-	longGlobalDiff.Lock()
-	defer globalDiff.Unlock()
-	defer longGlobalDiff.Unlock()
-	// maxAdjustedCount := 0
-	// globalDiff.WalkUnlocked(func(word string, count int) {
-	// 	longCount := int64(longGlobalDiff.GetUnlocked(word))
-	// 	// essentially 0, since we divide by the adjustmentRatio
-	// 	if longCount == 0 {
-	// 		return
-	// 	}
-	// 	adjustedCount := count - int(longCount/adjustmentRatio)
-	// 	if adjustedCount > maxAdjustedCount {
-	// 		maxAdjustedCount = adjustedCount
-	// 	}
-	// })
-
-	globalDiff.WalkUnlocked(func(word string, count int) {
+	focusDiff.Lock()
+	defer focusDiff.Unlock()
+	lockLongRate()
+	defer unlockLongRate()
+
+	focusDiff.WalkUnlocked(func(word string, count int) {
         // XXX: Special testing rule to get rid of hashtags
         if word[0] == '#' {
             return
         }
-		// if the count is already below the minCount, don't bother
-		if count < int(minCount) {
-			return
-		}
 
-		longCount := int64(longGlobalDiff.GetUnlocked(word))
-		// essentially 0, since we divide by the adjustmentRatio
-		if longCount == 0 {
+		input := lib.ScoreInput{Word: word, Count: count, WindowTweets: focusTweets, LongRate: longRateUnlocked(word), Chunks: chunks}
+		score, ok := scorer.Score(input)
+		if !ok || score.Score <= top[0].WordScore {
 			return
 		}
 
-		// normalize the count. So, if the count is less than the average usage of the the word over LONG_PERIOD, then it will me negative.
-		// In theory, this method should return higher counts for words that are being used more than average.
-		// (LONG_PERIOD / FOCUS_PERIOD) = the factor FOCUS_PERIOD is multiplied by for the LONG_PERIOD
-		// then we divide the long count by that factor to make it the average usage over the past LONG_PERIOD
-		//count -= int(longCount / (globalTweetCount / int64(FOCUS_PERIOD*AGG_SIZE)))
+		foundNonZero = true
+		pair := WordRankingPair{Word: word, Count: score.AdjustedCount, Multiple: score.Multiple, WordScore: score.Score}
+		for i := 0; i < len(top); i++ {
+			if score.Score <= top[i].WordScore {
+				// subtract one since the previous index is the one we are greater than
+				i -= 1
 
-		var multiple float32
-		if longCount < adjustmentRatio {
-			multiple = maxMultiple
-		} else {
-			multiple = float32(count) / float32(longCount/adjustmentRatio)
-		}
-
-		adjustedCount := count - int(longCount/adjustmentRatio)
-		// secret sauce formula. maybe change some of these values to be more empirical and based on statistics.
-		wordScore := (min(multiple-minMultiple, maxMultiple)/maxMultiple)*0.5 +
-			min(float32(count), maxAdjustedCount)/maxAdjustedCount*0.5
-
-		if adjustedCount > int(minCount) && multiple > minMultiple && wordScore > top[0].WordScore {
-			foundNonZero = true
-			for i := 0; i < len(top); i++ {
-				if wordScore <= top[i].WordScore {
-					// subtract one since the previous index is the one we are greater than
-					i -= 1
-
-					// shift all those less than <count> back 1
-					copy(top[:i], top[1:i+1])
-					// overwrite the current element
-					top[i] = WordRankingPair{Word: word, Count: adjustedCount, Multiple: multiple, WordScore: wordScore}
-					break
-				} else if i == len(top)-1 {
-					// shift all those less than <count> back 1
-					copy(top[:i], top[1:i+1])
-					// overwrite the current element
-					top[i] = WordRankingPair{Word: word, Count: adjustedCount, Multiple: multiple, WordScore: wordScore}
-					break
-				}
+				// shift all those less than <count> back 1
+				copy(top[:i], top[1:i+1])
+				// overwrite the current element
+				top[i] = pair
+				break
+			} else if i == len(top)-1 {
+				// shift all those less than <count> back 1
+				copy(top[:i], top[1:i+1])
+				top[i] = pair
+				break
 			}
 		}
 	})